@@ -2,49 +2,231 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"rwa/config"
+	"rwa/migration"
+	"rwa/pkg/apitoken"
 	"rwa/pkg/article"
+	"rwa/pkg/health"
+	"rwa/pkg/httpserver"
+	"rwa/pkg/lockout"
+	"rwa/pkg/logging"
+	"rwa/pkg/mail"
+	"rwa/pkg/metrics"
+	"rwa/pkg/oauth"
+	"rwa/pkg/openapi"
+	"rwa/pkg/ratelimit"
 	"rwa/pkg/session"
+	"rwa/pkg/sqlite"
+	"rwa/pkg/tracing"
 	"rwa/pkg/user"
+	"strings"
 	"syscall"
+	"time"
 
+	apitokenST "rwa/pkg/apitoken/storage"
 	articleST "rwa/pkg/article/storage"
+	lockoutST "rwa/pkg/lockout/storage"
+	mailST "rwa/pkg/mail/storage"
+	oauthST "rwa/pkg/oauth/storage"
+	ratelimitST "rwa/pkg/ratelimit/storage"
 	sessionST "rwa/pkg/session/storage"
 	userST "rwa/pkg/user/storage"
 
-	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 )
 
+var logger = logging.For("main")
+
+// fatal logs the message with the attributes and exits, as log.Fatal does.
+func fatal(msg string, args ...interface{}) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
-	cfg, err := config.GetConfig()
+	os.Exit(run())
+}
+
+// randomSecret returns 32 random bytes encoded in base64.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
 	if err != nil {
-		log.Fatalf("get config error: [%s]\n", err.Error())
+		return "", err
 	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
 
-	dsn := fmt.Sprintf(
-		"postgres://%s:%s@%s/%s?sslmode=disable",
-		cfg.DBusername,
-		cfg.DBpassword,
-		cfg.DBhost,
-		cfg.DBname,
-	)
+// run starts the app and returns the exit code once it is stopped, so that the
+// deferred closes run before the process exits.
+func run() int {
+	cfg, args, err := config.GetConfig(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		// the errors are a list for a person, not a log record
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if cfg.PrintConfig {
+		cfg.Print(os.Stdout)
+		return 0
+	}
 
-	db, err := sql.Open("postgres", dsn)
+	err = logging.Setup(os.Stderr, cfg.LogFormat, cfg.LogLevel, cfg.LogLevels)
 	if err != nil {
-		log.Fatalf("open sql connection failed, error: [%s]\n", err.Error())
+		fatal("set up logging failed", "error", err)
 	}
-	defer db.Close()
 
-	err = db.Ping()
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter, cfg.TraceOTLPendpoint, cfg.TraceSampleRatio)
 	if err != nil {
-		log.Fatalf("db  ping failed, error: [%s]\n", err.Error())
+		fatal("set up tracing failed", "error", err)
+	}
+	traced := cfg.TraceExporter != "none"
+
+	// a signal during startup stops the retries of the dependencies
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(args) > 0 && args[0] != "migrate" {
+		fatal("unknown command", "command", args[0])
+	}
+
+	lifetime := session.Lifetime{
+		Absolute:      cfg.SessionAbsoluteTTL,
+		Idle:          cfg.SessionIdleTTL,
+		TouchInterval: cfg.SessionTouchInterval,
+	}
+
+	checks := health.NewHealth(cfg.ReadinessTimeout)
+
+	var db *sql.DB
+	var dsn string
+	var userStorage user.Storage
+	var articleStorage article.Storage
+	var sessionStorage session.Storage
+	var tokenStorage apitoken.Storage
+	var lockoutStorage lockout.Storage
+	var outboxStorage mail.Storage
+	var oauthStorage oauth.Storage
+	switch cfg.Storage {
+	case "postgres":
+		dsn = cfg.PostgresDSN()
+
+		db, err = sql.Open("postgres", dsn)
+		if err != nil {
+			fatal("open sql connection failed", "error", err)
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(cfg.DBmaxOpenConns)
+		db.SetMaxIdleConns(cfg.DBmaxIdleConns)
+		db.SetConnMaxLifetime(cfg.DBconnMaxLifetime)
+		db.SetConnMaxIdleTime(cfg.DBconnMaxIdleTime)
+
+		// the database may start later than the API, as in docker compose
+		err = health.Retry(ctx, "postgres", cfg.DBstartupTimeout, db.PingContext)
+		if err != nil {
+			fatal("db ping failed", "error", err)
+		}
+
+		userStorage = userST.NewStorage(db)
+		articleStorage = articleST.NewStorage(db)
+		sessionStorage = sessionST.NewStorage(db, lifetime)
+		tokenStorage = apitokenST.NewStorage(db)
+		lockoutStorage = lockoutST.NewStorage(db)
+		outboxStorage = mailST.NewStorage(db)
+		oauthStorage = oauthST.NewStorage(db)
+	case "sqlite":
+		db, err = sqlite.Open(cfg.SQLitePath)
+		if err != nil {
+			fatal("open sqlite database failed", "error", err)
+		}
+		defer db.Close()
+		db.SetMaxOpenConns(cfg.DBmaxOpenConns)
+		db.SetMaxIdleConns(cfg.DBmaxIdleConns)
+
+		// the queries of the Postgres storages that run on SQLite as they are are reused
+		userStorage = userST.NewSQLiteStorage(db)
+		articleStorage = articleST.NewSQLiteStorage(db)
+		sessionStorage = sessionST.NewStorage(db, lifetime)
+		tokenStorage = apitokenST.NewSQLiteStorage(db)
+		lockoutStorage = lockoutST.NewStorage(db)
+		outboxStorage = mailST.NewSQLiteStorage(db)
+		oauthStorage = oauthST.NewStorage(db)
+	case "memory":
+		users := userST.NewMemoryStorage()
+		articles := articleST.NewMemoryStorage(users)
+		sessions := sessionST.NewMemoryStorage(lifetime)
+		tokens := apitokenST.NewMemoryStorage()
+		// rows that reference the user are removed with it, as ON DELETE CASCADE does
+		users.OnDelete = append(users.OnDelete, articles.DeleteAll, sessions.DeleteAll, tokens.DeleteAll)
+
+		userStorage = users
+		articleStorage = articles
+		sessionStorage = sessions
+		tokenStorage = tokens
+		lockoutStorage = lockoutST.NewMemoryStorage()
+		outboxStorage = mailST.NewMemoryStorage()
+		oauthStorage = oauthST.NewMemoryStorage()
+		logger.Warn("data is kept in memory and is lost on restart")
+	default:
+		fatal("unknown storage", "storage", cfg.Storage)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		if db == nil {
+			fatal("migrate needs --storage=postgres or --storage=sqlite")
+		}
+		migrator, err := migration.NewMigrator(db, cfg.Storage)
+		if err != nil {
+			fatal("load migrations failed", "error", err)
+		}
+		err = runMigrate(migrator, args[1:])
+		if err != nil {
+			fatal("migrate failed", "error", err)
+		}
+		return 0
+	}
+
+	if db != nil {
+		migrator, err := migration.NewMigrator(db, cfg.Storage)
+		if err != nil {
+			fatal("load migrations failed", "error", err)
+		}
+		if cfg.AutoMigrate {
+			err = migrator.Up()
+			if err != nil {
+				fatal("migrate failed", "error", err)
+			}
+		}
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			fatal("check migrations failed", "error", err)
+		}
+		if pending > 0 {
+			logger.Warn("schema migrations are not applied, run \"migrate up\"", "pending", pending)
+		}
+
+		checks.Add(cfg.Storage, db.PingContext)
+		checks.Add("migrations", health.MigrationsApplied(migrator))
+	}
+
+	if db != nil {
+		err = metrics.RegisterDB(db, cfg.Storage)
+		if err != nil {
+			fatal("register db metrics failed", "error", err)
+		}
 	}
 
 	whiteList := map[string]map[string]struct{}{
@@ -54,64 +236,355 @@ func main() {
 		"/api/users/login": {
 			"POST": struct{}{},
 		},
+		"/api/users/login/mfa": {
+			"POST": struct{}{},
+		},
+		"/api/users/verify": {
+			"GET": struct{}{},
+		},
+		"/api/users/password/forgot": {
+			"POST": struct{}{},
+		},
+		"/api/users/password/reset": {
+			"POST": struct{}{},
+		},
+		"/api/users/refresh": {
+			"POST": struct{}{},
+		},
 		"/api/articles": {
 			"GET": struct{}{},
 		},
 	}
 
+	var mailTransport mail.Mailer
+	switch cfg.MailTransport {
+	case "", "file":
+		mailTransport, err = mail.NewFileMailer(cfg.MailDir, cfg.MailFrom)
+		if err != nil {
+			fatal("create mail dir failed", "error", err)
+		}
+	case "smtp":
+		mailTransport = mail.NewSMTPMailer(cfg.SMTPhost, cfg.SMTPport, cfg.SMTPusername, cfg.SMTPpassword, cfg.MailFrom)
+	default:
+		fatal("unknown mail transport", "mail_transport", cfg.MailTransport)
+	}
+	outbox := mail.NewOutbox(outboxStorage)
+
+	done := make(chan struct{})
+
+	switch cfg.SessionStorage {
+	case "", "postgres":
+		// sessions stay in the storage chosen with --storage
+	case "redis":
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+		defer redisClient.Close()
+
+		pingRedis := func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}
+		err = health.Retry(ctx, "redis", cfg.DBstartupTimeout, pingRedis)
+		if err != nil {
+			fatal("redis ping failed", "error", err)
+		}
+		checks.Add("redis", pingRedis)
+		sessionStorage = sessionST.NewRedisStorage(redisClient, lifetime, "rwa:")
+	default:
+		fatal("unknown session storage", "session_storage", cfg.SessionStorage)
+	}
+	var sessionCache *session.Cache
+	if cfg.SessionCacheSize > 0 {
+		sessionCache = session.NewCache(sessionStorage, cfg.SessionCacheSize, cfg.SessionCacheTTL, cfg.SessionCacheNegTTL)
+		// without Postgres there is a single instance and nobody to notify
+		if dsn != "" {
+			notifier, err := sessionST.NewNotifier(db, dsn)
+			if err != nil {
+				fatal("listen session cache invalidations failed", "error", err)
+			}
+			sessionCache.Bus = notifier
+			go notifier.Listen(sessionCache, done)
+		}
+		sessionStorage = sessionCache
+	}
+
+	if traced {
+		userStorage = user.NewTracedStorage(userStorage)
+		articleStorage = article.NewTracedStorage(articleStorage)
+		sessionStorage = session.NewTracedStorage(sessionStorage)
+		tokenStorage = apitoken.NewTracedStorage(tokenStorage)
+		lockoutStorage = lockout.NewTracedStorage(lockoutStorage)
+		oauthStorage = oauth.NewTracedStorage(oauthStorage)
+		outbox.Storage = mail.NewTracedStorage(outbox.Storage)
+	}
+
+	var sessionUsers session.UserStorage = userStorage
+	if sessionCache != nil {
+		sessionCache.Users = userStorage
+		sessionUsers = sessionCache
+		// the cached roles and verified flags are dropped on every instance when they change
+		userStorage = user.NewChangeNotifier(userStorage, sessionCache.InvalidateUser)
+	}
+
 	sessionHandler := session.NewSessionHandler(
-		sessionST.NewStorage(db),
+		sessionStorage,
+		sessionUsers,
 		whiteList,
 	)
 
+	sessionHandler.Tokens = tokenStorage
+
+	sessionHandler.UnverifiedDenied = make(map[string]struct{})
+	for _, permission := range cfg.UnverifiedDeny {
+		sessionHandler.UnverifiedDenied[permission] = struct{}{}
+	}
+
+	var sessionManager session.Manager
+	var jwtManager *session.JWTManager
+	switch cfg.SessionBackend {
+	case "", "db":
+		sessionManager = sessionHandler
+	case "jwt":
+		keys, err := session.ParseSigningKeys(cfg.JWTkeys)
+		if err != nil {
+			fatal("parse jwt keys failed", "error", err)
+		}
+		jwtManager, err = session.NewJWTManager(sessionHandler, keys, cfg.JWTsigningKID, cfg.JWTaccessTTL)
+		if err != nil {
+			fatal("create jwt session manager failed", "error", err)
+		}
+		sessionManager = jwtManager
+	default:
+		fatal("unknown session backend", "session_backend", cfg.SessionBackend)
+	}
+
 	userManager := user.NewUserHandler(
-		userST.NewStorage(db),
-		sessionHandler,
+		userStorage,
+		sessionManager,
 	)
+	userManager.Mailer = outbox
+	verificationSecret := cfg.VerificationSecret
+	if verificationSecret == "" {
+		// only STORAGE=memory starts without the secret, the users do not outlive a restart either
+		verificationSecret, err = randomSecret()
+		if err != nil {
+			fatal("generate verification secret failed", "error", err)
+		}
+		logger.Warn("VERIFICATION_SECRET is not set, a random one is used and verification links stop working on restart")
+	}
+	userManager.Verification = user.NewVerificationTokens(verificationSecret, cfg.VerificationTTL)
+	userManager.PublicURL = cfg.PublicURL
+	userManager.PasswordResetTTL = cfg.PasswordResetTTL
+	userManager.MFAIssuer = cfg.MFAIssuer
+	userManager.MFAChallengeTTL = cfg.MFAChallengeTTL
+
+	argon2Params := user.DefaultArgon2Params
+	argon2Params.Time = uint32(cfg.Argon2Time)
+	argon2Params.Memory = uint32(cfg.Argon2Memory)
+	argon2Params.Threads = uint8(cfg.Argon2Threads)
+	scryptParams := user.DefaultScryptParams
+	scryptParams.LogN = uint8(cfg.ScryptLogN)
+
+	hashers := map[string]user.PasswordHasher{
+		"argon2id": user.NewArgon2Hasher(argon2Params),
+		"bcrypt":   user.NewBcryptHasher(cfg.BcryptCost),
+		"scrypt":   user.NewScryptHasher(scryptParams),
+	}
+	preferredHasher, ok := hashers[cfg.PasswordHasher]
+	if !ok {
+		fatal("unknown password hasher", "password_hasher", cfg.PasswordHasher)
+	}
+	userManager.Passwords = user.NewPasswords(preferredHasher, hashers["argon2id"], hashers["bcrypt"], hashers["scrypt"])
+
+	if len(cfg.OAuthProviders) > 0 {
+		providers := []*oauth.Provider{}
+		for _, provider := range cfg.OAuthProviders {
+			providers = append(providers, &oauth.Provider{
+				Name:         provider.Name,
+				Issuer:       provider.Issuer,
+				ClientID:     provider.ClientID,
+				ClientSecret: provider.ClientSecret,
+				RedirectURL:  cfg.PublicURL + "/api/users/oauth/" + provider.Name + "/callback",
+				Scopes:       provider.Scopes,
+			})
+			whiteList["/api/users/oauth/"+provider.Name+"/login"] = map[string]struct{}{"GET": {}}
+			whiteList["/api/users/oauth/"+provider.Name+"/callback"] = map[string]struct{}{"GET": {}}
+		}
+		userManager.OAuth = oauth.NewProviders(oauthStorage, cfg.OAuthStateTTL, providers...)
+	}
+
+	userManager.LoginGuard = lockout.NewGuard(
+		lockoutStorage,
+		userManager,
+		lockout.Policy{
+			MaxFailures: cfg.LoginMaxFailures,
+			BaseDelay:   cfg.LoginBaseDelay,
+			MaxDelay:    cfg.LoginMaxDelay,
+			Lockout:     cfg.LoginLockout,
+			Window:      cfg.LoginWindow,
+		},
+		lockout.Policy{
+			MaxFailures: cfg.LoginIPMaxFailures,
+			BaseDelay:   cfg.LoginBaseDelay,
+			MaxDelay:    cfg.LoginMaxDelay,
+			Lockout:     cfg.LoginLockout,
+			Window:      cfg.LoginWindow,
+		},
+	)
+
+	err = userManager.BootstrapAdmin(context.Background(), cfg.AdminEmail)
+	if err != nil {
+		fatal("bootstrap admin failed", "error", err)
+	}
 
 	articleManager := article.NewArticleHandler(
-		articleST.NewStorage(db),
-		sessionHandler,
+		articleStorage,
+		sessionManager,
 	)
 
-	router := mux.NewRouter()
-
-	//user
-	//white list
-	router.HandleFunc("/api/users", userManager.Register).Methods(http.MethodPost)
-	router.HandleFunc("/api/users/login", userManager.Login).Methods(http.MethodPost)
-	//other
-	router.HandleFunc("/api/user/logout", userManager.Logout).Methods(http.MethodGet)
-	router.HandleFunc("/api/user", userManager.GetUserInfo).Methods(http.MethodGet)
-	router.HandleFunc("/api/user", userManager.UpdateUserInfo).Methods(http.MethodPut)
-	router.HandleFunc("/api/user", userManager.DeleteUser).Methods(http.MethodDelete)
-
-	//article
-	//white list
-	router.HandleFunc("/api/articles", articleManager.ShowAll).Methods(http.MethodGet)
-	router.HandleFunc("/api/articles/{id:[0-9]+}", articleManager.ShowArticle).Methods(http.MethodGet)
-	//other
-	router.HandleFunc("/api/articles", articleManager.Create).Methods(http.MethodPost)
-	router.HandleFunc("/api/articles", articleManager.Update).Methods(http.MethodPut)
-	router.HandleFunc("/api/articles", articleManager.Delete).Methods(http.MethodDelete)
+	tokenManager := apitoken.NewTokenHandler(tokenStorage, sessionManager)
 
-	//middleware
-	router.Use(userManager.SessionManager.AuthMiddleware)
+	rateLimitRules, err := ratelimit.ParseRules(cfg.RateLimitRules)
+	if err != nil {
+		fatal("parse rate limit rules failed", "error", err)
+	}
 
-	server := http.Server{
-		Addr:    ":" + cfg.HTTPport,
-		Handler: router,
+	var rateLimitStorage ratelimit.Storage
+	switch cfg.RateLimitBackend {
+	case "", "memory":
+		rateLimitStorage = ratelimitST.NewMemoryStorage()
+	case "postgres":
+		rateLimitStorage = ratelimitST.NewStorage(db)
+	default:
+		fatal("unknown rate limit backend", "rate_limit_backend", cfg.RateLimitBackend)
 	}
 
+	if traced {
+		rateLimitStorage = ratelimit.NewTracedStorage(rateLimitStorage)
+	}
+
+	limiter := ratelimit.NewLimiter(rateLimitStorage, rateLimitRules, sessionManager)
+
+	go limiter.Sweep(time.Minute, done)
+	go userManager.LoginGuard.Sweep(time.Hour, done)
+	go sessionManager.Sweep(cfg.SessionSweepInterval, done)
+	go outbox.Dispatch(mailTransport, 5*time.Second, done)
+	// the password resets are sent until the server is stopped, the last
+	// requests may still queue them after done is closed
+	resetsDone := make(chan struct{})
+	resetsSent := make(chan struct{})
 	go func() {
-		log.Println("start server on:", cfg.HTTPport)
-		server.ListenAndServe()
+		userManager.SendPasswordResets(resetsDone)
+		close(resetsSent)
 	}()
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	router := newRouter(&handlers{
+		users:    userManager,
+		articles: articleManager,
+		tokens:   tokenManager,
+		sessions: sessionManager,
+		jwt:      jwtManager,
+	})
+
+	//middleware
+	router.Use(tracing.RouteMiddleware)
+	router.Use(metrics.Middleware)
+	router.Use(tracing.Middleware("IPRateLimitMiddleware", limiter.IPMiddleware))
+	router.Use(tracing.Middleware("AuthMiddleware", sessionManager.AuthMiddleware))
+	router.Use(tracing.Middleware("UserRateLimitMiddleware", limiter.UserMiddleware))
+	if cfg.OpenAPIvalidate {
+		validator, err := openapi.NewValidator()
+		if err != nil {
+			fatal("load openapi request schemas failed", "error", err)
+		}
+		router.Use(tracing.Middleware("ValidateMiddleware", validator.Middleware))
+	}
+
+	// the probes are answered before the logs, the traces and the session check,
+	// an orchestrator polling them every few seconds would flood them
+	rootRouter := http.NewServeMux()
+	rootRouter.HandleFunc("/healthz", checks.Live)
+	rootRouter.HandleFunc("/readyz", checks.Ready)
+	// the document and its UI are public and are not API routes, they are
+	// served past the session check and the rate limits
+	rootRouter.Handle(openapi.SpecPath, logging.Middleware(openapi.Handler()))
+	rootRouter.Handle(openapi.DocsPath, logging.Middleware(openapi.DocsHandler()))
+	rootRouter.Handle("/", tracing.Handler(logging.Middleware(httpserver.Recover(router))))
+
+	// browsers take Strict-Transport-Security only over HTTPS, served here or by a proxy
+	hsts := cfg.HSTSmaxAge
+	if cfg.TLScertFile == "" && !strings.HasPrefix(cfg.PublicURL, "https://") {
+		hsts = 0
+	}
+
+	limits := httpserver.Limits{
+		ReadHeaderTimeout: cfg.HTTPreadHeaderTimeout,
+		ReadTimeout:       cfg.HTTPreadTimeout,
+		WriteTimeout:      cfg.HTTPwriteTimeout,
+		IdleTimeout:       cfg.HTTPidleTimeout,
+		MaxHeaderBytes:    cfg.HTTPmaxHeaderBytes,
+	}
+	serveErrs := make(chan error, 2)
+
+	server := httpserver.NewServer("api", cfg.HTTPport, httpserver.SecurityHeaders(hsts)(rootRouter), limits)
+	server.CertFile = cfg.TLScertFile
+	server.KeyFile = cfg.TLSkeyFile
+	err = server.Start(serveErrs)
+	if err != nil {
+		fatal("start server failed", "error", err)
+	}
+
+	// the admin port is kept apart from the API, so it can be closed to the outside
+	var adminServer *httpserver.Server
+	if cfg.AdminPort != 0 {
+		adminRouter := http.NewServeMux()
+		adminRouter.Handle("/metrics", metrics.Handler())
+		adminRouter.HandleFunc("/healthz", checks.Live)
+		adminRouter.HandleFunc("/readyz", checks.Ready)
+		adminServer = httpserver.NewServer("admin", cfg.AdminPort, adminRouter, limits)
+
+		err = adminServer.Start(serveErrs)
+		if err != nil {
+			fatal("start admin server failed", "error", err)
+		}
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+	case err = <-serveErrs:
+		logger.Error("serve failed", "error", err)
+		exitCode = 1
+	}
+	stop()
+
+	// the load balancer sees /readyz fail and stops sending requests,
+	// then the requests in flight are drained by Stop
+	checks.ShutDown()
+	logger.Info("shutting down", "delay", cfg.ShutdownDelay.String())
+	time.Sleep(cfg.ShutdownDelay)
 
-	server.Shutdown(context.Background())
-	log.Println("server stopped")
+	close(done)
+	err = server.Stop(cfg.ShutdownTimeout)
+	if err != nil {
+		logger.Error("stop server error", "error", err)
+	}
+	if adminServer != nil {
+		err = adminServer.Stop(cfg.ShutdownTimeout)
+		if err != nil {
+			logger.Error("stop admin server error", "error", err)
+		}
+	}
+	close(resetsDone)
+	<-resetsSent
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	err = shutdownTracing(flushCtx)
+	if err != nil {
+		logger.Error("flush spans error", "error", err)
+	}
+	logger.Info("server stopped")
+	return exitCode
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"rwa/migration"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = "usage: migrate up|down|status|to <version>"
+
+// runMigrate runs the migrate subcommand, args are the words after "migrate".
+func runMigrate(migrator *migration.Migrator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return fmt.Errorf(migrateUsage)
+		}
+		err := migrator.Up()
+		if err != nil {
+			return err
+		}
+	case "down":
+		if len(args) != 1 {
+			return fmt.Errorf(migrateUsage)
+		}
+		err := migrator.Down()
+		if err != nil {
+			return err
+		}
+	case "to":
+		if len(args) != 2 {
+			return fmt.Errorf(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("bad version: [%s]", args[1])
+		}
+		err = migrator.To(version)
+		if err != nil {
+			return err
+		}
+	case "status":
+		if len(args) != 1 {
+			return fmt.Errorf(migrateUsage)
+		}
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d %-30s %s\n", status.Version, status.Name, applied)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"rwa/pkg/apitoken"
+	"rwa/pkg/article"
+	"rwa/pkg/rbac"
+	"rwa/pkg/session"
+	"rwa/pkg/user"
+
+	"github.com/gorilla/mux"
+)
+
+// handlers serve the routes of the API.
+type handlers struct {
+	users    *user.UserHandler
+	articles *article.ArticleHandler
+	tokens   *apitoken.TokenHandler
+	sessions session.Manager
+	// jwt is set with SESSION_BACKEND=jwt, it serves the refresh route
+	jwt *session.JWTManager
+}
+
+// newRouter registers the routes of the API, the middleware is added by the caller.
+// Every route must have an operation in the openapi document, routes_test.go checks it.
+func newRouter(h *handlers) *mux.Router {
+	router := mux.NewRouter()
+
+	//user
+	//white list
+	router.HandleFunc("/api/users", h.users.Register).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/login", h.users.Login).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/login/mfa", h.users.LoginMFA).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/verify", h.users.Verify).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/password/forgot", h.users.ForgotPassword).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/password/reset", h.users.ResetPassword).Methods(http.MethodPost)
+	router.HandleFunc("/api/users/oauth/{provider}/login", h.users.OAuthLogin).Methods(http.MethodGet)
+	router.HandleFunc("/api/users/oauth/{provider}/callback", h.users.OAuthCallback).Methods(http.MethodGet)
+	if h.jwt != nil {
+		router.HandleFunc("/api/users/refresh", h.jwt.Refresh).Methods(http.MethodPost)
+	}
+	//other
+	router.Handle("/api/user/logout", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.users.Logout))).Methods(http.MethodGet)
+	router.Handle("/api/user", h.sessions.RequirePermission(rbac.PermUserRead)(http.HandlerFunc(h.users.GetUserInfo))).Methods(http.MethodGet)
+	router.Handle("/api/user", h.sessions.RequirePermission(rbac.PermUserWrite)(http.HandlerFunc(h.users.UpdateUserInfo))).Methods(http.MethodPut)
+	router.Handle("/api/user", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.users.DeleteUser))).Methods(http.MethodDelete)
+	router.Handle("/api/user/verify", h.sessions.RequirePermission(rbac.PermUserWrite)(http.HandlerFunc(h.users.ResendVerification))).Methods(http.MethodPost)
+	router.Handle("/api/user/sessions", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.sessions.List))).Methods(http.MethodGet)
+	router.Handle("/api/user/sessions/{id}", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.sessions.Revoke))).Methods(http.MethodDelete)
+	router.Handle("/api/user/mfa", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.users.EnrollMFA))).Methods(http.MethodPost)
+	router.Handle("/api/user/mfa/enable", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.users.EnableMFA))).Methods(http.MethodPost)
+	router.Handle("/api/user/mfa/disable", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.users.DisableMFA))).Methods(http.MethodPost)
+	router.Handle("/api/user/tokens", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.tokens.List))).Methods(http.MethodGet)
+	router.Handle("/api/user/tokens", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.tokens.Create))).Methods(http.MethodPost)
+	router.Handle("/api/user/tokens/{id:[0-9]+}", h.sessions.RequirePermission(rbac.PermCredentialsManage)(http.HandlerFunc(h.tokens.Delete))).Methods(http.MethodDelete)
+	//admin
+	router.Handle("/api/admin/users/{id:[0-9]+}/roles", h.sessions.RequirePermission(rbac.PermUsersManage)(http.HandlerFunc(h.users.SetRoles))).Methods(http.MethodPut)
+
+	//article
+	//white list
+	router.HandleFunc("/api/articles", h.articles.ShowAll).Methods(http.MethodGet)
+	router.HandleFunc("/api/articles/{id:[0-9]+}", h.articles.ShowArticle).Methods(http.MethodGet)
+	//other
+	router.Handle("/api/articles", h.sessions.RequirePermission(rbac.PermArticlesWrite)(http.HandlerFunc(h.articles.Create))).Methods(http.MethodPost)
+	router.Handle("/api/articles", h.sessions.RequirePermission(rbac.PermArticlesWrite)(http.HandlerFunc(h.articles.Update))).Methods(http.MethodPut)
+	router.Handle("/api/articles", h.sessions.RequirePermission(rbac.PermArticlesWrite)(http.HandlerFunc(h.articles.Delete))).Methods(http.MethodDelete)
+
+	return router
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"rwa/pkg/apitoken"
+	"rwa/pkg/article"
+	"rwa/pkg/openapi"
+	"rwa/pkg/session"
+	"rwa/pkg/user"
+	"testing"
+)
+
+// TestRoutesDocumented fails when a route is added without its operation in the
+// openapi document. The handlers are never called, so they need no storages.
+func TestRoutesDocumented(t *testing.T) {
+	sessions := &session.SessionHandler{}
+	router := newRouter(&handlers{
+		users:    &user.UserHandler{},
+		articles: &article.ArticleHandler{},
+		tokens:   &apitoken.TokenHandler{},
+		sessions: sessions,
+		// with the refresh route of SESSION_BACKEND=jwt
+		jwt: &session.JWTManager{SessionHandler: sessions},
+	})
+
+	err := openapi.CheckRoutes(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,37 +1,428 @@
 package config
 
 import (
-	"os"
-
-	"github.com/joho/godotenv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	HTTPport   string
-	DBhost     string
-	DBname     string
-	DBusername string
-	DBpassword string
+	HTTPport   int
+	AdminPort  int
+	AdminEmail string
+
+	ReadinessTimeout time.Duration
+	ShutdownDelay    time.Duration
+	ShutdownTimeout  time.Duration
+
+	HTTPreadHeaderTimeout time.Duration
+	HTTPreadTimeout       time.Duration
+	HTTPwriteTimeout      time.Duration
+	HTTPidleTimeout       time.Duration
+	HTTPmaxHeaderBytes    int
+	TLScertFile           string
+	TLSkeyFile            string
+	HSTSmaxAge            time.Duration
+	OpenAPIvalidate       bool
+
+	LogFormat string
+	LogLevel  slog.Level
+	LogLevels map[string]slog.Level
+
+	TraceExporter     string
+	TraceOTLPendpoint string
+	TraceSampleRatio  float64
+
+	DBhost            string
+	DBport            int
+	DBname            string
+	DBusername        string
+	DBpassword        string
+	DBsslMode         string
+	DBconnectTimeout  time.Duration
+	DBstartupTimeout  time.Duration
+	DBmaxOpenConns    int
+	DBmaxIdleConns    int
+	DBconnMaxLifetime time.Duration
+	DBconnMaxIdleTime time.Duration
+
+	Storage     string
+	SQLitePath  string
+	AutoMigrate bool
+
+	RateLimitBackend string
+	RateLimitRules   string
+
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginBaseDelay     time.Duration
+	LoginMaxDelay      time.Duration
+	LoginLockout       time.Duration
+	LoginWindow        time.Duration
+
+	SessionBackend       string
+	SessionStorage       string
+	JWTkeys              string
+	JWTsigningKID        string
+	JWTaccessTTL         time.Duration
+	SessionAbsoluteTTL   time.Duration
+	SessionIdleTTL       time.Duration
+	SessionTouchInterval time.Duration
+	SessionSweepInterval time.Duration
+	SessionCacheSize     int
+	SessionCacheTTL      time.Duration
+	SessionCacheNegTTL   time.Duration
+
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+
+	PublicURL          string
+	VerificationSecret string
+	VerificationTTL    time.Duration
+	UnverifiedDeny     []string
+	PasswordResetTTL   time.Duration
+
+	MFAIssuer       string
+	MFAChallengeTTL time.Duration
+
+	PasswordHasher string
+	Argon2Time     int
+	Argon2Memory   int
+	Argon2Threads  int
+	BcryptCost     int
+	ScryptLogN     int
+
+	OAuthProviders []OAuthProvider
+	OAuthStateTTL  time.Duration
+
+	MailTransport string
+	MailFrom      string
+	MailDir       string
+	SMTPhost      string
+	SMTPport      string
+	SMTPusername  string
+	SMTPpassword  string
+
+	// PrintConfig asks to print the settings with Print instead of starting.
+	PrintConfig bool
+
+	layers *layers
 }
 
-func GetConfig() (*Config, error) {
+type OAuthProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
 
-	file, err := os.Open("./config/app.env")
+// GetConfig resolves the settings from the defaults, the env file, the environment
+// and the flags in args, each layer overrides the previous one. It returns the
+// arguments left after the flags. Every invalid setting is reported in the error.
+func GetConfig(args []string) (*Config, []string, error) {
+	l, rest, printConfig, err := newLayers(args)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer file.Close()
 
-	env, err := godotenv.Parse(file)
-	if err != nil {
-		return nil, err
+	cfg := &Config{
+		HTTPport:   l.int("HTTP_PORT"),
+		AdminPort:  l.int("ADMIN_PORT"),
+		AdminEmail: l.get("ADMIN_EMAIL"),
+
+		ReadinessTimeout: l.duration("READINESS_TIMEOUT"),
+		ShutdownDelay:    l.duration("SHUTDOWN_DELAY"),
+		ShutdownTimeout:  l.duration("SHUTDOWN_TIMEOUT"),
+
+		HTTPreadHeaderTimeout: l.duration("HTTP_READ_HEADER_TIMEOUT"),
+		HTTPreadTimeout:       l.duration("HTTP_READ_TIMEOUT"),
+		HTTPwriteTimeout:      l.duration("HTTP_WRITE_TIMEOUT"),
+		HTTPidleTimeout:       l.duration("HTTP_IDLE_TIMEOUT"),
+		HTTPmaxHeaderBytes:    l.int("HTTP_MAX_HEADER_BYTES"),
+		TLScertFile:           l.get("TLS_CERT_FILE"),
+		TLSkeyFile:            l.get("TLS_KEY_FILE"),
+		HSTSmaxAge:            l.duration("HSTS_MAX_AGE"),
+		OpenAPIvalidate:       l.bool("OPENAPI_VALIDATE"),
+
+		LogFormat: l.oneOf("LOG_FORMAT", "json", "text"),
+		LogLevel:  l.level("LOG_LEVEL"),
+		LogLevels: l.levels("LOG_LEVELS"),
+
+		TraceExporter:     l.oneOf("TRACE_EXPORTER", "none", "stdout", "otlp"),
+		TraceOTLPendpoint: l.get("TRACE_OTLP_ENDPOINT"),
+		TraceSampleRatio:  l.float("TRACE_SAMPLE_RATIO"),
+
+		DBhost:            l.get("DB_HOST"),
+		DBport:            l.int("DB_PORT"),
+		DBname:            l.get("DB_NAME"),
+		DBusername:        l.get("DB_USERNAME"),
+		DBpassword:        l.get("DB_PASSWORD"),
+		DBsslMode:         l.oneOf("DB_SSL_MODE", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+		DBconnectTimeout:  l.duration("DB_CONNECT_TIMEOUT"),
+		DBstartupTimeout:  l.duration("DB_STARTUP_TIMEOUT"),
+		DBmaxOpenConns:    l.int("DB_MAX_OPEN_CONNS"),
+		DBmaxIdleConns:    l.int("DB_MAX_IDLE_CONNS"),
+		DBconnMaxLifetime: l.duration("DB_CONN_MAX_LIFETIME"),
+		DBconnMaxIdleTime: l.duration("DB_CONN_MAX_IDLE_TIME"),
+
+		Storage:     l.oneOf("STORAGE", "postgres", "sqlite", "memory"),
+		SQLitePath:  l.get("SQLITE_PATH"),
+		AutoMigrate: l.bool("AUTO_MIGRATE"),
+
+		RateLimitBackend: l.oneOf("RATE_LIMIT_BACKEND", "memory", "postgres"),
+		RateLimitRules:   l.get("RATE_LIMIT_RULES"),
+
+		LoginMaxFailures:   l.int("LOGIN_MAX_FAILURES"),
+		LoginIPMaxFailures: l.int("LOGIN_IP_MAX_FAILURES"),
+		LoginBaseDelay:     l.duration("LOGIN_BASE_DELAY"),
+		LoginMaxDelay:      l.duration("LOGIN_MAX_DELAY"),
+		LoginLockout:       l.duration("LOGIN_LOCKOUT"),
+		LoginWindow:        l.duration("LOGIN_WINDOW"),
+
+		SessionBackend:       l.oneOf("SESSION_BACKEND", "db", "jwt"),
+		SessionStorage:       l.oneOf("SESSION_STORAGE", "postgres", "redis"),
+		JWTkeys:              l.get("JWT_KEYS"),
+		JWTsigningKID:        l.get("JWT_SIGNING_KID"),
+		JWTaccessTTL:         l.duration("JWT_ACCESS_TTL"),
+		SessionAbsoluteTTL:   l.duration("SESSION_ABSOLUTE_TTL"),
+		SessionIdleTTL:       l.duration("SESSION_IDLE_TTL"),
+		SessionTouchInterval: l.duration("SESSION_TOUCH_INTERVAL"),
+		SessionSweepInterval: l.duration("SESSION_SWEEP_INTERVAL"),
+		SessionCacheSize:     l.int("SESSION_CACHE_SIZE"),
+		SessionCacheTTL:      l.duration("SESSION_CACHE_TTL"),
+		SessionCacheNegTTL:   l.duration("SESSION_CACHE_NEGATIVE_TTL"),
+
+		RedisAddr:     l.get("REDIS_ADDR"),
+		RedisPassword: l.get("REDIS_PASSWORD"),
+		RedisDB:       l.int("REDIS_DB"),
+
+		PublicURL:          l.get("PUBLIC_URL"),
+		VerificationSecret: l.get("VERIFICATION_SECRET"),
+		VerificationTTL:    l.duration("VERIFICATION_TTL"),
+		UnverifiedDeny:     l.list("UNVERIFIED_DENY"),
+		PasswordResetTTL:   l.duration("PASSWORD_RESET_TTL"),
+
+		MFAIssuer:       l.get("MFA_ISSUER"),
+		MFAChallengeTTL: l.duration("MFA_CHALLENGE_TTL"),
+
+		PasswordHasher: l.oneOf("PASSWORD_HASHER", "argon2id", "bcrypt", "scrypt"),
+		Argon2Time:     l.int("PASSWORD_ARGON2_TIME"),
+		Argon2Memory:   l.int("PASSWORD_ARGON2_MEMORY"),
+		Argon2Threads:  l.int("PASSWORD_ARGON2_THREADS"),
+		BcryptCost:     l.int("PASSWORD_BCRYPT_COST"),
+		ScryptLogN:     l.int("PASSWORD_SCRYPT_LN"),
+
+		OAuthStateTTL: l.duration("OAUTH_STATE_TTL"),
+
+		MailTransport: l.oneOf("MAIL_TRANSPORT", "file", "smtp"),
+		MailFrom:      l.get("MAIL_FROM"),
+		MailDir:       l.get("MAIL_DIR"),
+		SMTPhost:      l.get("SMTP_HOST"),
+		SMTPport:      l.get("SMTP_PORT"),
+		SMTPusername:  l.get("SMTP_USERNAME"),
+		SMTPpassword:  l.get("SMTP_PASSWORD"),
+
+		PrintConfig: printConfig,
+		layers:      l,
+	}
+
+	for _, name := range l.list("OAUTH_PROVIDERS") {
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+		provider := OAuthProvider{
+			Name:         name,
+			Issuer:       l.get(prefix + "ISSUER"),
+			ClientID:     l.get(prefix + "CLIENT_ID"),
+			ClientSecret: l.get(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(l.get(prefix + "SCOPES")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			l.errorf("%sISSUER and %sCLIENT_ID must be not empty", prefix, prefix)
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = []string{"email", "profile"}
+		}
+		cfg.OAuthProviders = append(cfg.OAuthProviders, provider)
+	}
+
+	// --print-config shows whatever was given, even if it is not valid
+	if !printConfig {
+		cfg.validate()
+		if len(l.errs) > 0 {
+			return nil, nil, fmt.Errorf("invalid config:\n%w", errors.Join(l.errs...))
+		}
+	}
+
+	return cfg, rest, nil
+}
+
+// minSecretLen is the shortest HMAC key taken, 32 bytes as the SHA-256 output.
+const minSecretLen = 32
+
+// placeholderSecrets are the values of examples that are never taken as keys.
+var placeholderSecrets = map[string]bool{
+	"change-me": true,
+	"changeme":  true,
+	"secret":    true,
+	"password":  true,
+}
+
+func (cfg *Config) validate() {
+	l := cfg.layers
+
+	if !l.invalid["HTTP_PORT"] && (cfg.HTTPport < 1 || cfg.HTTPport > 65535) {
+		l.errorf("HTTP_PORT: want 1..65535")
+	}
+	if !l.invalid["ADMIN_PORT"] && (cfg.AdminPort < 0 || cfg.AdminPort > 65535) {
+		l.errorf("ADMIN_PORT: want 0..65535")
+	}
+	if cfg.AdminPort != 0 && cfg.AdminPort == cfg.HTTPport {
+		l.errorf("ADMIN_PORT: want a port other than HTTP_PORT")
+	}
+	if cfg.ReadinessTimeout <= 0 {
+		l.errorf("READINESS_TIMEOUT: want > 0")
+	}
+	if cfg.ShutdownDelay < 0 {
+		l.errorf("SHUTDOWN_DELAY: want >= 0")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		l.errorf("SHUTDOWN_TIMEOUT: want > 0")
+	}
+	if cfg.HTTPreadHeaderTimeout <= 0 || cfg.HTTPidleTimeout <= 0 {
+		l.errorf("HTTP_READ_HEADER_TIMEOUT and HTTP_IDLE_TIMEOUT: want > 0")
+	}
+	if cfg.HTTPreadTimeout < 0 || cfg.HTTPwriteTimeout < 0 {
+		l.errorf("HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT: want >= 0")
+	}
+	if !l.invalid["HTTP_MAX_HEADER_BYTES"] && cfg.HTTPmaxHeaderBytes < 4096 {
+		l.errorf("HTTP_MAX_HEADER_BYTES: want >= 4096")
+	}
+	if (cfg.TLScertFile == "") != (cfg.TLSkeyFile == "") {
+		l.errorf("TLS_CERT_FILE and TLS_KEY_FILE: want both or none")
+	}
+	if cfg.HSTSmaxAge < 0 {
+		l.errorf("HSTS_MAX_AGE: want >= 0")
+	}
+	if !l.invalid["TRACE_SAMPLE_RATIO"] && (cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1) {
+		l.errorf("TRACE_SAMPLE_RATIO: want 0..1")
+	}
+	if cfg.Storage == "postgres" {
+		if !l.invalid["DB_PORT"] && (cfg.DBport < 1 || cfg.DBport > 65535) {
+			l.errorf("DB_PORT: want 1..65535")
+		}
+		if cfg.DBhost == "" || cfg.DBname == "" || cfg.DBusername == "" {
+			l.errorf("DB_HOST, DB_NAME and DB_USERNAME must be not empty")
+		}
+	}
+	if cfg.DBmaxOpenConns < 0 || cfg.DBmaxIdleConns < 0 {
+		l.errorf("DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS: want >= 0")
+	}
+	if cfg.DBmaxOpenConns > 0 && cfg.DBmaxIdleConns > cfg.DBmaxOpenConns {
+		l.errorf("DB_MAX_IDLE_CONNS: want <= DB_MAX_OPEN_CONNS")
+	}
+	if cfg.DBconnectTimeout < time.Second {
+		l.errorf("DB_CONNECT_TIMEOUT: want >= 1s")
 	}
+	if cfg.DBstartupTimeout < cfg.DBconnectTimeout {
+		l.errorf("DB_STARTUP_TIMEOUT: want >= DB_CONNECT_TIMEOUT")
+	}
+	if cfg.Storage != "postgres" && cfg.RateLimitBackend == "postgres" {
+		l.errorf("RATE_LIMIT_BACKEND: postgres needs STORAGE=postgres")
+	}
+	if cfg.VerificationSecret == "" {
+		// the memory storage is for demos, a random secret is generated at start
+		if cfg.Storage != "memory" {
+			l.errorf("VERIFICATION_SECRET must be not empty")
+		}
+	} else if placeholderSecrets[strings.ToLower(cfg.VerificationSecret)] {
+		l.errorf("VERIFICATION_SECRET: placeholder value, set a random key")
+	} else if len(cfg.VerificationSecret) < minSecretLen {
+		l.errorf("VERIFICATION_SECRET: want at least %d bytes", minSecretLen)
+	}
+	if cfg.SessionBackend == "jwt" && (cfg.JWTkeys == "" || cfg.JWTsigningKID == "") {
+		l.errorf("JWT_KEYS and JWT_SIGNING_KID must be not empty with SESSION_BACKEND=jwt")
+	}
+	for _, setting := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"SESSION_ABSOLUTE_TTL", cfg.SessionAbsoluteTTL},
+		{"SESSION_IDLE_TTL", cfg.SessionIdleTTL},
+		{"SESSION_SWEEP_INTERVAL", cfg.SessionSweepInterval},
+		{"JWT_ACCESS_TTL", cfg.JWTaccessTTL},
+		{"VERIFICATION_TTL", cfg.VerificationTTL},
+		{"PASSWORD_RESET_TTL", cfg.PasswordResetTTL},
+		{"MFA_CHALLENGE_TTL", cfg.MFAChallengeTTL},
+		{"OAUTH_STATE_TTL", cfg.OAuthStateTTL},
+		{"LOGIN_BASE_DELAY", cfg.LoginBaseDelay},
+		{"LOGIN_MAX_DELAY", cfg.LoginMaxDelay},
+		{"LOGIN_LOCKOUT", cfg.LoginLockout},
+		{"LOGIN_WINDOW", cfg.LoginWindow},
+	} {
+		if !l.invalid[setting.key] && setting.value <= 0 {
+			l.errorf("%s: want > 0", setting.key)
+		}
+	}
+	if cfg.SessionTouchInterval < 0 || cfg.SessionCacheNegTTL < 0 {
+		l.errorf("SESSION_TOUCH_INTERVAL and SESSION_CACHE_NEGATIVE_TTL: want >= 0")
+	}
+	if cfg.LoginMaxDelay < cfg.LoginBaseDelay {
+		l.errorf("LOGIN_MAX_DELAY: want >= LOGIN_BASE_DELAY")
+	}
+	if !l.invalid["LOGIN_MAX_FAILURES"] && cfg.LoginMaxFailures < 1 {
+		l.errorf("LOGIN_MAX_FAILURES: want >= 1")
+	}
+	if !l.invalid["LOGIN_IP_MAX_FAILURES"] && cfg.LoginIPMaxFailures < 1 {
+		l.errorf("LOGIN_IP_MAX_FAILURES: want >= 1")
+	}
+	if cfg.SessionCacheSize < 0 {
+		l.errorf("SESSION_CACHE_SIZE: want >= 0")
+	}
+	if cfg.SessionCacheSize > 0 && !l.invalid["SESSION_CACHE_TTL"] && cfg.SessionCacheTTL <= 0 {
+		l.errorf("SESSION_CACHE_TTL: want > 0 with SESSION_CACHE_SIZE > 0")
+	}
+	// a cache hit does not move the idle expiry, the storage must be asked within SESSION_IDLE_TTL
+	if cfg.SessionCacheSize > 0 && cfg.SessionCacheTTL >= cfg.SessionIdleTTL {
+		l.errorf("SESSION_CACHE_TTL: want < SESSION_IDLE_TTL")
+	}
+	if cfg.MailTransport == "smtp" && cfg.SMTPhost == "" {
+		l.errorf("SMTP_HOST must be not empty with MAIL_TRANSPORT=smtp")
+	}
+	if cfg.Argon2Time < 1 || cfg.Argon2Memory < 8*cfg.Argon2Threads || cfg.Argon2Threads < 1 || cfg.Argon2Threads > 255 {
+		l.errorf("PASSWORD_ARGON2_*: want time >= 1, threads 1..255 and memory >= 8*threads KiB")
+	}
+	if cfg.BcryptCost < 4 || cfg.BcryptCost > 31 {
+		l.errorf("PASSWORD_BCRYPT_COST: want 4..31")
+	}
+	if cfg.ScryptLogN < 1 || cfg.ScryptLogN > 30 {
+		l.errorf("PASSWORD_SCRYPT_LN: want 1..30")
+	}
+}
+
+// PostgresDSN is the connection string of the Postgres storage.
+func (cfg *Config) PostgresDSN() string {
+	query := url.Values{}
+	query.Set("sslmode", cfg.DBsslMode)
+	query.Set("connect_timeout", strconv.Itoa(int(cfg.DBconnectTimeout.Seconds())))
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.DBusername, cfg.DBpassword),
+		Host:     net.JoinHostPort(cfg.DBhost, strconv.Itoa(cfg.DBport)),
+		Path:     "/" + cfg.DBname,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
 
-	return &Config{
-		HTTPport:   env["HTTP_PORT"],
-		DBhost:     env["DB_HOST"],
-		DBname:     env["DB_NAME"],
-		DBusername: env["DB_USERNAME"],
-		DBpassword: env["DB_PASSWORD"],
-	}, err
+// Print writes the resolved settings, secrets are redacted.
+func (cfg *Config) Print(w io.Writer) {
+	cfg.layers.print(w, cfg.OAuthProviders)
 }
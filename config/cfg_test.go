@@ -0,0 +1,76 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// testArgs load app.env with a valid secret, a test adds the setting it checks.
+func testArgs(args ...string) []string {
+	return append([]string{
+		"--config=app.env",
+		"--verification-secret=abcdefghijklmnopqrstuvwxyz0123456789",
+	}, args...)
+}
+
+func TestGetConfigDefaults(t *testing.T) {
+	_, _, err := GetConfig(testArgs())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"zero sweep interval", []string{"--session-sweep-interval=0"}, "SESSION_SWEEP_INTERVAL: want > 0"},
+		{"negative sweep interval", []string{"--session-sweep-interval=-1m"}, "SESSION_SWEEP_INTERVAL: want > 0"},
+		{"zero absolute ttl", []string{"--session-absolute-ttl=0"}, "SESSION_ABSOLUTE_TTL: want > 0"},
+		{"zero idle ttl", []string{"--session-idle-ttl=0"}, "SESSION_IDLE_TTL: want > 0"},
+		{"negative touch interval", []string{"--session-touch-interval=-1s"}, "SESSION_TOUCH_INTERVAL and SESSION_CACHE_NEGATIVE_TTL: want >= 0"},
+		{"zero jwt access ttl", []string{"--jwt-access-ttl=0"}, "JWT_ACCESS_TTL: want > 0"},
+		{"zero verification ttl", []string{"--verification-ttl=0"}, "VERIFICATION_TTL: want > 0"},
+		{"negative password reset ttl", []string{"--password-reset-ttl=-1h"}, "PASSWORD_RESET_TTL: want > 0"},
+		{"zero mfa challenge ttl", []string{"--mfa-challenge-ttl=0"}, "MFA_CHALLENGE_TTL: want > 0"},
+		{"zero oauth state ttl", []string{"--oauth-state-ttl=0"}, "OAUTH_STATE_TTL: want > 0"},
+		{"zero cache ttl", []string{"--session-cache-ttl=0"}, "SESSION_CACHE_TTL: want > 0 with SESSION_CACHE_SIZE > 0"},
+		{"cache ttl over idle ttl", []string{"--session-cache-ttl=2h", "--session-idle-ttl=1h"}, "SESSION_CACHE_TTL: want < SESSION_IDLE_TTL"},
+		{"negative cache negative ttl", []string{"--session-cache-negative-ttl=-1s"}, "SESSION_TOUCH_INTERVAL and SESSION_CACHE_NEGATIVE_TTL: want >= 0"},
+		{"zero login max failures", []string{"--login-max-failures=0"}, "LOGIN_MAX_FAILURES: want >= 1"},
+		{"zero login ip max failures", []string{"--login-ip-max-failures=0"}, "LOGIN_IP_MAX_FAILURES: want >= 1"},
+		{"zero login base delay", []string{"--login-base-delay=0"}, "LOGIN_BASE_DELAY: want > 0"},
+		{"login max delay under base", []string{"--login-base-delay=1m", "--login-max-delay=1s"}, "LOGIN_MAX_DELAY: want >= LOGIN_BASE_DELAY"},
+		{"zero login lockout", []string{"--login-lockout=0"}, "LOGIN_LOCKOUT: want > 0"},
+		{"zero login window", []string{"--login-window=0"}, "LOGIN_WINDOW: want > 0"},
+		{"empty secret", []string{"--verification-secret="}, "VERIFICATION_SECRET must be not empty"},
+		{"bad duration", []string{"--login-window=soon"}, "LOGIN_WINDOW: want a duration"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := GetConfig(testArgs(tt.args...))
+			if err == nil {
+				t.Fatalf("config is valid, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error %q does not contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMemorySecret(t *testing.T) {
+	// the memory storage generates the secret at start
+	_, _, err := GetConfig(testArgs("--storage=memory", "--verification-secret="))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = GetConfig(testArgs("--storage=memory", "--verification-secret=change-me"))
+	if err == nil {
+		t.Fatal("placeholder secret is accepted with the memory storage")
+	}
+}
@@ -0,0 +1,374 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const defaultFile = "./config/app.env"
+
+// setting is a key known to the config. Every setting can be given as a flag
+// named after the key: HTTP_PORT is --http-port.
+type setting struct {
+	key    string
+	def    string
+	usage  string
+	secret bool
+	isBool bool
+}
+
+// settings are the known keys with their defaults. The OAUTH_<NAME>_* keys of the
+// providers are read from the environment and the file only.
+var settings = []setting{
+	{key: "HTTP_PORT", def: "8080", usage: "port of the API"},
+	{key: "ADMIN_PORT", def: "9090", usage: "port of /metrics, 0 disables it"},
+	{key: "ADMIN_EMAIL", usage: "user granted the admin role"},
+	{key: "READINESS_TIMEOUT", def: "2s", usage: "timeout of the checks of /readyz"},
+	{key: "SHUTDOWN_DELAY", def: "5s", usage: "time /readyz fails before the server stops taking requests"},
+	{key: "SHUTDOWN_TIMEOUT", def: "30s", usage: "time to finish the requests in flight on shutdown"},
+
+	{key: "HTTP_READ_HEADER_TIMEOUT", def: "5s", usage: "time to read the request headers"},
+	{key: "HTTP_READ_TIMEOUT", def: "30s", usage: "time to read the request, 0 is unlimited"},
+	{key: "HTTP_WRITE_TIMEOUT", def: "30s", usage: "time to serve the request, 0 is unlimited"},
+	{key: "HTTP_IDLE_TIMEOUT", def: "2m", usage: "keep-alive connections are closed after this time"},
+	{key: "HTTP_MAX_HEADER_BYTES", def: "65536", usage: "size limit of the request headers"},
+	{key: "TLS_CERT_FILE", usage: "PEM certificate of the API, HTTPS is served if set"},
+	{key: "TLS_KEY_FILE", usage: "PEM private key of TLS_CERT_FILE"},
+	{key: "HSTS_MAX_AGE", def: "8760h", usage: "max-age of Strict-Transport-Security over HTTPS, 0 disables it"},
+	{key: "OPENAPI_VALIDATE", def: "false", usage: "reject request bodies not matching the OpenAPI document", isBool: true},
+
+	{key: "LOG_FORMAT", def: "json", usage: "json or text"},
+	{key: "LOG_LEVEL", def: "info", usage: "debug, info, warn or error"},
+	{key: "LOG_LEVELS", usage: "levels of single packages: <package>=<level>, ..."},
+
+	{key: "TRACE_EXPORTER", def: "none", usage: "none, stdout or otlp"},
+	{key: "TRACE_OTLP_ENDPOINT", usage: "URL of the OTLP/HTTP collector, OTEL_EXPORTER_OTLP_* if empty"},
+	{key: "TRACE_SAMPLE_RATIO", def: "1", usage: "share of new traces recorded, 0..1"},
+
+	{key: "STORAGE", def: "postgres", usage: "postgres, sqlite or memory"},
+	{key: "SQLITE_PATH", def: "./data/rwa.db", usage: "database file of the sqlite storage"},
+	{key: "AUTO_MIGRATE", def: "false", usage: "apply pending migrations on startup", isBool: true},
+
+	{key: "DB_HOST", def: "localhost", usage: "Postgres host"},
+	{key: "DB_PORT", def: "5432", usage: "Postgres port"},
+	{key: "DB_NAME", def: "realworld", usage: "Postgres database"},
+	{key: "DB_USERNAME", def: "root", usage: "Postgres user"},
+	{key: "DB_PASSWORD", usage: "Postgres password", secret: true},
+	{key: "DB_SSL_MODE", def: "disable", usage: "disable, allow, prefer, require, verify-ca or verify-full"},
+	{key: "DB_CONNECT_TIMEOUT", def: "5s", usage: "timeout of a new database connection"},
+	{key: "DB_STARTUP_TIMEOUT", def: "1m", usage: "time to wait for Postgres and Redis on startup"},
+	{key: "DB_MAX_OPEN_CONNS", def: "25", usage: "open connections limit, 0 is unlimited"},
+	{key: "DB_MAX_IDLE_CONNS", def: "5", usage: "idle connections kept in the pool"},
+	{key: "DB_CONN_MAX_LIFETIME", def: "30m", usage: "connections are closed after this time, 0 keeps them"},
+	{key: "DB_CONN_MAX_IDLE_TIME", def: "5m", usage: "idle connections are closed after this time, 0 keeps them"},
+
+	{key: "RATE_LIMIT_BACKEND", def: "memory", usage: "memory or postgres"},
+	{key: "RATE_LIMIT_RULES", usage: "<group> <method> <path> <limit>/<period> <ip|user|route>; ..."},
+
+	{key: "LOGIN_MAX_FAILURES", def: "5"},
+	{key: "LOGIN_IP_MAX_FAILURES", def: "50"},
+	{key: "LOGIN_BASE_DELAY", def: "1s"},
+	{key: "LOGIN_MAX_DELAY", def: "1m"},
+	{key: "LOGIN_LOCKOUT", def: "15m"},
+	{key: "LOGIN_WINDOW", def: "1h"},
+
+	{key: "SESSION_BACKEND", def: "db", usage: "db or jwt"},
+	{key: "SESSION_STORAGE", def: "postgres", usage: "postgres or redis"},
+	{key: "JWT_KEYS", usage: "<kid>:<HS256|EdDSA>:<secret or base64 ed25519 seed>; ...", secret: true},
+	{key: "JWT_SIGNING_KID"},
+	{key: "JWT_ACCESS_TTL", def: "15m"},
+	{key: "SESSION_ABSOLUTE_TTL", def: "720h"},
+	{key: "SESSION_IDLE_TTL", def: "168h"},
+	{key: "SESSION_TOUCH_INTERVAL", def: "5m"},
+	{key: "SESSION_SWEEP_INTERVAL", def: "10m"},
+	{key: "SESSION_CACHE_SIZE", def: "10000", usage: "cached session checks, 0 disables the cache"},
+	{key: "SESSION_CACHE_TTL", def: "30s"},
+	{key: "SESSION_CACHE_NEGATIVE_TTL", def: "5s"},
+
+	{key: "REDIS_ADDR", def: "localhost:6379"},
+	{key: "REDIS_PASSWORD", secret: true},
+	{key: "REDIS_DB", def: "0"},
+
+	{key: "PUBLIC_URL", def: "http://localhost:8080", usage: "address of the API in links sent by mail"},
+	{key: "VERIFICATION_SECRET", usage: "key of email verification links", secret: true},
+	{key: "VERIFICATION_TTL", def: "24h"},
+	{key: "UNVERIFIED_DENY", usage: "permissions denied until the email is verified, comma separated"},
+	{key: "PASSWORD_RESET_TTL", def: "1h"},
+
+	{key: "MFA_ISSUER", def: "API-Articles"},
+	{key: "MFA_CHALLENGE_TTL", def: "5m"},
+
+	{key: "PASSWORD_HASHER", def: "argon2id", usage: "argon2id, bcrypt or scrypt"},
+	{key: "PASSWORD_ARGON2_TIME", def: "1"},
+	{key: "PASSWORD_ARGON2_MEMORY", def: "65536", usage: "KiB"},
+	{key: "PASSWORD_ARGON2_THREADS", def: "4"},
+	{key: "PASSWORD_BCRYPT_COST", def: "12"},
+	{key: "PASSWORD_SCRYPT_LN", def: "15"},
+
+	{key: "OAUTH_PROVIDERS", usage: "OpenID Connect providers, comma separated"},
+	{key: "OAUTH_STATE_TTL", def: "10m"},
+
+	{key: "MAIL_TRANSPORT", def: "file", usage: "file or smtp"},
+	{key: "MAIL_FROM", def: "noreply@localhost"},
+	{key: "MAIL_DIR", def: "./mail"},
+	{key: "SMTP_HOST"},
+	{key: "SMTP_PORT", def: "25"},
+	{key: "SMTP_USERNAME"},
+	{key: "SMTP_PASSWORD", secret: true},
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// boolValue lets bool settings be given as a bare flag.
+type boolValue struct {
+	set func(string) error
+}
+
+func (v boolValue) String() string     { return "" }
+func (v boolValue) Set(s string) error { return v.set(s) }
+func (v boolValue) IsBoolFlag() bool   { return true }
+
+// source names the layer a value came from.
+type source string
+
+const (
+	fromDefault source = "default"
+	fromFile    source = "file"
+	fromEnv     source = "env"
+	fromFlag    source = "flag"
+)
+
+// layers resolves a key as defaults < file < environment < flags
+// and collects every parse error, so all of them are reported at once.
+type layers struct {
+	file     map[string]string
+	fileName string
+	flags    map[string]string
+	defaults map[string]string
+	errs     []error
+	// invalid keys could not be parsed, they are not checked further
+	invalid map[string]bool
+}
+
+// newLayers parses the flags and reads the file given with --config, CONFIG_FILE
+// or the default one. The default file is optional, it is looked up in the working
+// directory and then next to the executable.
+func newLayers(args []string) (*layers, []string, bool, error) {
+	l := &layers{
+		file:     make(map[string]string),
+		flags:    make(map[string]string),
+		defaults: make(map[string]string),
+		invalid:  make(map[string]bool),
+	}
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "env file with the settings, "+defaultFile+" if empty")
+	printConfig := fs.Bool("print-config", false, "print the resolved settings with secrets redacted and exit")
+	for _, s := range settings {
+		s := s
+		l.defaults[s.key] = s.def
+		set := func(value string) error {
+			l.flags[s.key] = value
+			return nil
+		}
+		usage := s.usage
+		if usage == "" {
+			usage = s.key
+		}
+		if s.def != "" {
+			usage += " (default \"" + s.def + "\")"
+		}
+		if s.isBool {
+			fs.Var(boolValue{set: set}, flagName(s.key), usage)
+		} else {
+			fs.Func(flagName(s.key), usage, set)
+		}
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	name := *configFile
+	required := name != ""
+	if !required {
+		name = defaultFile
+		if _, err := os.Stat(name); err != nil {
+			if exe, err := os.Executable(); err == nil {
+				name = filepath.Join(filepath.Dir(exe), defaultFile)
+			}
+		}
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		if required || !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, false, err
+		}
+		return l, fs.Args(), *printConfig, nil
+	}
+	defer file.Close()
+
+	l.file, err = godotenv.Parse(file)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("%s: %w", name, err)
+	}
+	l.fileName = name
+
+	return l, fs.Args(), *printConfig, nil
+}
+
+func (l *layers) lookup(key string) (string, source) {
+	if value, ok := l.flags[key]; ok {
+		return value, fromFlag
+	}
+	if value, ok := os.LookupEnv(key); ok {
+		return value, fromEnv
+	}
+	if value, ok := l.file[key]; ok {
+		return value, fromFile
+	}
+	return l.defaults[key], fromDefault
+}
+
+// get returns the value, an empty value falls back to the default.
+func (l *layers) get(key string) string {
+	value, _ := l.lookup(key)
+	if value == "" {
+		return l.defaults[key]
+	}
+	return value
+}
+
+func (l *layers) errorf(format string, args ...interface{}) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+func (l *layers) int(key string) int {
+	result, err := strconv.Atoi(l.get(key))
+	if err != nil {
+		l.errorf("%s: want an integer, got [%s]", key, l.get(key))
+		l.invalid[key] = true
+	}
+	return result
+}
+
+func (l *layers) duration(key string) time.Duration {
+	result, err := time.ParseDuration(l.get(key))
+	if err != nil {
+		l.errorf("%s: want a duration like 30s or 5m, got [%s]", key, l.get(key))
+		l.invalid[key] = true
+	}
+	return result
+}
+
+func (l *layers) float(key string) float64 {
+	result, err := strconv.ParseFloat(l.get(key), 64)
+	if err != nil {
+		l.errorf("%s: want a number, got [%s]", key, l.get(key))
+		l.invalid[key] = true
+	}
+	return result
+}
+
+func (l *layers) bool(key string) bool {
+	result, err := strconv.ParseBool(l.get(key))
+	if err != nil {
+		l.errorf("%s: want true or false, got [%s]", key, l.get(key))
+		l.invalid[key] = true
+	}
+	return result
+}
+
+func (l *layers) level(key string) slog.Level {
+	var result slog.Level
+	err := result.UnmarshalText([]byte(l.get(key)))
+	if err != nil {
+		l.errorf("%s: want debug, info, warn or error, got [%s]", key, l.get(key))
+		l.invalid[key] = true
+	}
+	return result
+}
+
+// levels parses a list of <name>=<level> pairs.
+func (l *layers) levels(key string) map[string]slog.Level {
+	result := make(map[string]slog.Level)
+	for _, pair := range l.list(key) {
+		name, value, _ := strings.Cut(pair, "=")
+		var level slog.Level
+		err := level.UnmarshalText([]byte(strings.TrimSpace(value)))
+		if err != nil || strings.TrimSpace(name) == "" {
+			l.errorf("%s: want <package>=<level>, got [%s]", key, pair)
+			l.invalid[key] = true
+			continue
+		}
+		result[strings.TrimSpace(name)] = level
+	}
+	return result
+}
+
+func (l *layers) list(key string) []string {
+	result := []string{}
+	for _, value := range strings.Split(l.get(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// oneOf reports an error if the value is not one of allowed.
+func (l *layers) oneOf(key string, allowed ...string) string {
+	value := l.get(key)
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	l.errorf("%s: want one of %s, got [%s]", key, strings.Join(allowed, ", "), value)
+	l.invalid[key] = true
+	return value
+}
+
+// print writes the settings as KEY=value lines with the layer each value came from.
+// Secrets are redacted, keys of the configured OAuth providers are listed too.
+func (l *layers) print(w io.Writer, oauthProviders []OAuthProvider) {
+	if l.fileName != "" {
+		fmt.Fprintf(w, "# file: %s\n", l.fileName)
+	}
+
+	line := func(key string, secret bool) {
+		value, src := l.lookup(key)
+		if value == "" {
+			value, src = l.defaults[key], fromDefault
+		}
+		if secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(w, "%s=%q # %s\n", key, value, src)
+	}
+
+	for _, s := range settings {
+		line(s.key, s.secret)
+	}
+	for _, provider := range oauthProviders {
+		prefix := "OAUTH_" + strings.ToUpper(provider.Name) + "_"
+		line(prefix+"ISSUER", false)
+		line(prefix+"CLIENT_ID", false)
+		line(prefix+"CLIENT_SECRET", true)
+		line(prefix+"SCOPES", false)
+	}
+}
@@ -0,0 +1,346 @@
+// Package migration keeps the database schema as numbered migrations embedded in the
+// binary. Every dialect has a directory of <version>_<name>.up.sql and
+// <version>_<name>.down.sql files, applied versions are kept in schema_migrations.
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockKey is the Postgres advisory lock taken while migrating, so that instances
+// started at the same time do not apply a migration twice.
+const lockKey = 7283150042
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	DB *sql.DB
+	// Dialect is postgres or sqlite.
+	Dialect    string
+	Migrations []*Migration
+}
+
+// NewMigrator loads the migrations of the dialect sorted by version.
+func NewMigrator(db *sql.DB, dialect string) (*Migrator, error) {
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("unknown migration dialect: [%s]", dialect)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, migrationName, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("bad migration file name: [%s]", name)
+		}
+
+		body, err := fs.ReadFile(files, path.Join(dialect, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if m.Name != migrationName {
+			return nil, fmt.Errorf("migration %d has two names: [%s] and [%s]", version, m.Name, migrationName)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return &Migrator{
+		DB:         db,
+		Dialect:    dialect,
+		Migrations: migrations,
+	}, nil
+}
+
+// Latest is the version of the last known migration.
+func (m *Migrator) Latest() int {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Up applies every migration that is not applied yet.
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down() error {
+	return m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.Migrations[i].Version]; ok {
+				return m.apply(conn, m.Migrations[i], false)
+			}
+		}
+		return nil
+	})
+}
+
+// To applies the migrations up to version and reverts the applied ones above it,
+// version 0 reverts everything.
+func (m *Migrator) To(version int) error {
+	if version != 0 && m.find(version) == nil {
+		return fmt.Errorf("unknown migration version: [%d]", version)
+	}
+
+	return m.locked(func(conn *sql.Conn) error {
+		applied, err := m.applied(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; ok && migration.Version > version {
+				err = m.apply(conn, migration, false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				err = m.apply(conn, migration, true)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Status lists the known migrations with the time they were applied. It only reads
+// the database, so it is cheap enough for the readiness probe: without the
+// schema_migrations table every migration is pending.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied := map[int]time.Time{}
+	exists, err := m.tableExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		applied, err = m.applied(ctx, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := []*Status{}
+	for _, migration := range m.Migrations {
+		status := &Status{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// Pending returns the number of known migrations that are not applied.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) withConn(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		"version" bigint PRIMARY KEY,
+		"name" varchar(255) NOT NULL,
+		"applied_at" timestamp NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	return f(conn)
+}
+
+// locked runs f holding the advisory lock on Postgres. SQLite has a single writer,
+// apply checks the version again inside its transaction instead.
+func (m *Migrator) locked(f func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		if m.Dialect != "postgres" {
+			return f(conn)
+		}
+
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+
+		return f(conn)
+	})
+}
+
+// tableExists reports if schema_migrations is created, without creating it.
+func (m *Migrator) tableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	query := "SELECT to_regclass('schema_migrations') IS NOT NULL"
+	if m.Dialect == "sqlite" {
+		query = "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')"
+	}
+
+	var exists bool
+	err := conn.QueryRowContext(ctx, query).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// apply runs the up or down script of the migration and records it in one transaction.
+func (m *Migrator) apply(conn *sql.Conn, migration *Migration, up bool) error {
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var isApplied bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT version FROM schema_migrations WHERE version = $1)", migration.Version).Scan(&isApplied)
+	if err != nil {
+		return err
+	}
+	if isApplied == up {
+		// another instance got here first
+		return nil
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, migration.Up)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations(version,name,applied_at) VALUES($1,$2,$3)",
+				migration.Version, migration.Name, time.Now(),
+			)
+		}
+	} else {
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+		_, err = tx.ExecContext(ctx, migration.Down)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS oauth_states;
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS mail_outbox;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS articles;
+DROP TABLE IF EXISTS users;
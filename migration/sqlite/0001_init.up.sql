@@ -0,0 +1,128 @@
+-- Arrays are kept as JSON arrays of strings, times as text written by the sqlite driver.
+
+CREATE TABLE IF NOT EXISTS users (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "email" varchar(100) UNIQUE NOT NULL,
+    "username" varchar(100) UNIQUE NOT NULL,
+    "password_hashed" blob NOT NULL,
+    "bio" text,
+    "image" varchar(255),
+    "roles" text NOT NULL DEFAULT '["author"]',
+    "verified" boolean NOT NULL DEFAULT false,
+    "verified_at" timestamp,
+    "created_at" timestamp,
+    "updated_at" timestamp
+);
+
+CREATE TABLE IF NOT EXISTS articles (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" int NOT NULL,
+    "title" varchar(255) NOT NULL,
+    "slug" varchar(255) NOT NULL,
+    "description" text,
+    "body" text,
+    "tag_list" text,
+    "created_at" timestamp,
+    "updated_at" timestamp,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    "id" varchar(36) PRIMARY KEY,
+    "session_key" varchar(36) NOT NULL,
+    "user_id" int,
+    "user_agent" varchar(255),
+    "ip" varchar(64),
+    "created_at" timestamp NOT NULL,
+    "last_seen_at" timestamp NOT NULL,
+    "expires_at" timestamp NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_session_key_idx ON sessions (session_key);
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    "key" varchar(255) PRIMARY KEY,
+    "failures" int NOT NULL,
+    "last_failure_at" timestamp NOT NULL,
+    "blocked_until" timestamp
+);
+
+CREATE TABLE IF NOT EXISTS mail_outbox (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "recipient" varchar(100) NOT NULL,
+    "subject" varchar(255) NOT NULL,
+    "body" text NOT NULL,
+    "attempts" int NOT NULL DEFAULT 0,
+    "last_error" text,
+    "created_at" timestamp NOT NULL,
+    "next_attempt_at" timestamp NOT NULL,
+    "sent_at" timestamp
+);
+CREATE INDEX IF NOT EXISTS mail_outbox_pending_idx ON mail_outbox (next_attempt_at) WHERE sent_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    "token_hash" blob PRIMARY KEY,
+    "user_id" int NOT NULL,
+    "created_at" timestamp NOT NULL,
+    "expires_at" timestamp NOT NULL,
+    "used_at" timestamp,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    "provider" varchar(50) NOT NULL,
+    "subject" varchar(255) NOT NULL,
+    "user_id" int NOT NULL,
+    "created_at" timestamp NOT NULL,
+    PRIMARY KEY (provider, subject),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS oauth_states (
+    "state" varchar(64) PRIMARY KEY,
+    "provider" varchar(50) NOT NULL,
+    "verifier" varchar(128) NOT NULL,
+    "nonce" varchar(64) NOT NULL,
+    "expires_at" timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    "id" integer PRIMARY KEY AUTOINCREMENT,
+    "user_id" int NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "token_hash" blob UNIQUE NOT NULL,
+    "scopes" text NOT NULL,
+    "created_at" timestamp NOT NULL,
+    "expires_at" timestamp,
+    "last_used_at" timestamp,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS api_tokens_user_id ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS user_mfa (
+    "user_id" int PRIMARY KEY,
+    "secret" varchar(64) NOT NULL,
+    "enabled" boolean NOT NULL DEFAULT false,
+    "last_step" bigint NOT NULL DEFAULT 0,
+    "created_at" timestamp NOT NULL,
+    "enabled_at" timestamp,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    "user_id" int NOT NULL,
+    "code_hash" blob NOT NULL,
+    "used_at" timestamp,
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    "token_hash" blob PRIMARY KEY,
+    "user_id" int NOT NULL,
+    "attempts" int NOT NULL DEFAULT 0,
+    "expires_at" timestamp NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
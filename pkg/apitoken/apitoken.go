@@ -0,0 +1,218 @@
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rwa/pkg/logging"
+	"rwa/pkg/rbac"
+	"rwa/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var logger = logging.For("apitoken")
+
+// Prefix marks personal access tokens, so AuthMiddleware can tell them from session keys.
+const Prefix = "rwa_pat_"
+
+// Scopes that may be given to a token, a token never gets more than its owner roles grant.
+var Scopes = map[string]struct{}{
+	rbac.PermArticlesWrite: {},
+	rbac.PermUserRead:      {},
+	rbac.PermUserWrite:     {},
+}
+
+type TokenHandler struct {
+	Storage        Storage
+	SessionManager SessionManager
+}
+
+func NewTokenHandler(storage Storage, sessionManager SessionManager) *TokenHandler {
+	return &TokenHandler{
+		Storage:        storage,
+		SessionManager: sessionManager,
+	}
+}
+
+type Storage interface {
+	Add(ctx context.Context, token *Token, tokenHash []byte) error
+	// CheckToken returns sql.ErrNoRows for unknown and expired tokens and records the use.
+	CheckToken(ctx context.Context, tokenHash []byte) (*Token, error)
+	List(ctx context.Context, userID int) ([]*Token, error)
+	// Delete returns sql.ErrNoRows if the user has no token with the id.
+	Delete(ctx context.Context, userID, id int) error
+}
+
+type SessionManager interface {
+	IdFromSessionContext(r *http.Request) (int, error)
+}
+
+type Token struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+func IsToken(raw string) bool {
+	return strings.HasPrefix(raw, Prefix)
+}
+
+func Hash(raw string) []byte {
+	sum := sha256.Sum256([]byte(raw))
+	return sum[:]
+}
+
+func generate() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type createRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// Create issues a token, its value is shown only in this response.
+func (th *TokenHandler) Create(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := th.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	dataFromBody := make(map[string]*createRequest)
+	err = json.Unmarshal(body, &dataFromBody)
+	if err != nil {
+		logger.WarnContext(r.Context(), "unmarshal body json error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	req, ok := dataFromBody["token"]
+	if !ok || req == nil {
+		utils.SendErrMessage(w, r, "no token data", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		utils.SendErrMessage(w, r, "name must be not empty", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		utils.SendErrMessage(w, r, "scopes must be not empty", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if _, ok := Scopes[scope]; !ok {
+			utils.SendErrMessage(w, r, fmt.Sprintf("unknown scope: %s", scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		utils.SendErrMessage(w, r, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	raw, err := generate()
+	if err != nil {
+		logger.ErrorContext(r.Context(), "generate token error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token := &Token{
+		UserID:    userID,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	err = th.Storage.Add(r.Context(), token, Hash(raw))
+	if err != nil {
+		logger.ErrorContext(r.Context(), "add token error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := utils.Response{
+		"token": token,
+		"value": raw,
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	utils.SendResponse(w, r, response)
+}
+
+func (th *TokenHandler) List(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := th.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := th.Storage.List(r.Context(), userID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "list tokens error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := utils.Response{
+		"tokens":      tokens,
+		"tokensCount": len(tokens),
+	}
+
+	utils.SendResponse(w, r, response)
+}
+
+func (th *TokenHandler) Delete(w http.ResponseWriter, r *http.Request) {
+
+	userID, err := th.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	err = th.Storage.Delete(r.Context(), userID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "bad id, no token", http.StatusNotFound)
+			return
+		}
+		logger.ErrorContext(r.Context(), "delete token error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"rwa/pkg/apitoken"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errTokenExists = errors.New("token with this hash exists")
+
+// MemoryStorage keeps tokens in the process, everything is lost on restart.
+type MemoryStorage struct {
+	mu     sync.Mutex
+	lastID int
+	tokens map[string]*apitoken.Token
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		tokens: make(map[string]*apitoken.Token),
+	}
+}
+
+func copyToken(token *apitoken.Token) *apitoken.Token {
+	c := *token
+	c.Scopes = append([]string(nil), token.Scopes...)
+	if token.ExpiresAt != nil {
+		expiresAt := *token.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if token.LastUsedAt != nil {
+		lastUsedAt := *token.LastUsedAt
+		c.LastUsedAt = &lastUsedAt
+	}
+	return &c
+}
+
+func (st *MemoryStorage) Add(ctx context.Context, token *apitoken.Token, tokenHash []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.tokens[string(tokenHash)]; ok {
+		return errTokenExists
+	}
+
+	st.lastID++
+	token.ID = st.lastID
+	saved := copyToken(token)
+	saved.LastUsedAt = nil
+	st.tokens[string(tokenHash)] = saved
+	return nil
+}
+
+func (st *MemoryStorage) CheckToken(ctx context.Context, tokenHash []byte) (*apitoken.Token, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	saved, ok := st.tokens[string(tokenHash)]
+	if !ok || (saved.ExpiresAt != nil && !now.Before(*saved.ExpiresAt)) {
+		return nil, sql.ErrNoRows
+	}
+
+	if saved.LastUsedAt == nil || now.Sub(*saved.LastUsedAt) >= lastUsedPrecision {
+		saved.LastUsedAt = &now
+	}
+	return copyToken(saved), nil
+}
+
+func (st *MemoryStorage) List(ctx context.Context, userID int) ([]*apitoken.Token, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	tokens := []*apitoken.Token{}
+	for _, saved := range st.tokens {
+		if saved.UserID == userID {
+			tokens = append(tokens, copyToken(saved))
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (st *MemoryStorage) Delete(ctx context.Context, userID, id int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for hash, saved := range st.tokens {
+		if saved.ID == id && saved.UserID == userID {
+			delete(st.tokens, hash)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// DeleteAll removes every token of the user, it is called when the user is deleted.
+func (st *MemoryStorage) DeleteAll(ctx context.Context, userID int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for hash, saved := range st.tokens {
+		if saved.UserID == userID {
+			delete(st.tokens, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/apitoken"
+	"rwa/pkg/sqlite"
+	"time"
+)
+
+// SQLiteStorage keeps tokens in SQLite, scopes are a JSON array.
+type SQLiteStorage struct {
+	*Storage
+}
+
+func NewSQLiteStorage(db *sql.DB) *SQLiteStorage {
+	return &SQLiteStorage{
+		Storage: NewStorage(db),
+	}
+}
+
+func (st *SQLiteStorage) Add(ctx context.Context, token *apitoken.Token, tokenHash []byte) error {
+	err := st.db.QueryRowContext(ctx, "INSERT INTO api_tokens(user_id,name,token_hash,scopes,created_at,expires_at) VALUES($1,$2,$3,$4,$5,$6) RETURNING id",
+		token.UserID, token.Name, tokenHash, sqlite.StringArray(token.Scopes), token.CreatedAt, token.ExpiresAt,
+	).Scan(&token.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *SQLiteStorage) CheckToken(ctx context.Context, tokenHash []byte) (*apitoken.Token, error) {
+	now := time.Now()
+	token := &apitoken.Token{}
+	var scopes sqlite.StringArray
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := st.db.QueryRowContext(ctx, `SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at
+	FROM api_tokens
+	WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > $2)`,
+		tokenHash, now,
+	).Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = scopes
+
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) >= lastUsedPrecision {
+		_, err = st.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", now, token.ID)
+		if err != nil {
+			return nil, err
+		}
+		lastUsedAt.Time = now
+	}
+	token.LastUsedAt = &lastUsedAt.Time
+
+	return token, nil
+}
+
+func (st *SQLiteStorage) List(ctx context.Context, userID int) ([]*apitoken.Token, error) {
+	rows, err := st.db.QueryContext(ctx, "SELECT id, name, scopes, created_at, expires_at, last_used_at FROM api_tokens WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*apitoken.Token{}
+	for rows.Next() {
+		token := &apitoken.Token{UserID: userID}
+		var scopes sqlite.StringArray
+		var expiresAt, lastUsedAt sql.NullTime
+		err = rows.Scan(&token.ID, &token.Name, &scopes, &token.CreatedAt, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = scopes
+		if expiresAt.Valid {
+			token.ExpiresAt = &expiresAt.Time
+		}
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
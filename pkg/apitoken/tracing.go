@@ -0,0 +1,45 @@
+package apitoken
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) Add(ctx context.Context, token *Token, tokenHash []byte) error {
+	ctx, span := tracing.Start(ctx, "apitoken.Storage.Add")
+	err := s.Storage.Add(ctx, token, tokenHash)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) CheckToken(ctx context.Context, tokenHash []byte) (*Token, error) {
+	ctx, span := tracing.Start(ctx, "apitoken.Storage.CheckToken")
+	result, err := s.Storage.CheckToken(ctx, tokenHash)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) List(ctx context.Context, userID int) ([]*Token, error) {
+	ctx, span := tracing.Start(ctx, "apitoken.Storage.List")
+	result, err := s.Storage.List(ctx, userID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) Delete(ctx context.Context, userID, id int) error {
+	ctx, span := tracing.Start(ctx, "apitoken.Storage.Delete")
+	err := s.Storage.Delete(ctx, userID, id)
+	tracing.End(span, err)
+	return err
+}
@@ -1,10 +1,12 @@
 package article
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"log"
 	"net/http"
+	"rwa/pkg/logging"
+	"rwa/pkg/metrics"
+	"rwa/pkg/rbac"
 	"rwa/pkg/utils"
 	"strconv"
 	"time"
@@ -13,6 +15,8 @@ import (
 	"github.com/mdigger/translit"
 )
 
+var logger = logging.For("article")
+
 type ArticleHandler struct {
 	Storage        Storage
 	SessionManager SessionManager
@@ -26,16 +30,17 @@ func NewArticleHandler(storage Storage, sessionManager SessionManager) *ArticleH
 }
 
 type Storage interface {
-	Add(new *Article) (int, error)
-	Update(article *Article, userID int) error
-	Delete(articleID, userID int) error
-	GetArticles(filters map[string]string) ([]*Article, error)
-	GetArticleWithID(id int) (*Article, error)
+	Add(ctx context.Context, new *Article) (int, error)
+	Update(ctx context.Context, article *Article, userID int) error
+	Delete(ctx context.Context, articleID, userID int) error
+	GetArticles(ctx context.Context, filters map[string]string) ([]*Article, error)
+	GetArticleWithID(ctx context.Context, id int) (*Article, error)
 	GetErrNoUpdate() error
 }
 
 type SessionManager interface {
 	IdFromSessionContext(r *http.Request) (int, error)
+	HasPermission(r *http.Request, permission string) bool
 }
 
 type AuthorManager interface{}
@@ -64,7 +69,7 @@ func (ah *ArticleHandler) Create(w http.ResponseWriter, r *http.Request) {
 	var err error
 	author.ID, err = ah.SessionManager.IdFromSessionContext(r)
 	if err != nil {
-		log.Printf("get user id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -90,12 +95,13 @@ func (ah *ArticleHandler) Create(w http.ResponseWriter, r *http.Request) {
 	newArticle.Slug = translit.Ru(newArticle.Title)
 	newArticle.Author = author
 
-	id, err := ah.Storage.Add(newArticle)
+	id, err := ah.Storage.Add(r.Context(), newArticle)
 	if err != nil {
-		log.Printf("add new article error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "add new article error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	metrics.ArticlesCreated.Inc()
 
 	response := utils.Response{
 		"article": utils.Response{
@@ -116,9 +122,9 @@ func (ah *ArticleHandler) ShowAll(w http.ResponseWriter, r *http.Request) {
 		params["tag"] = tag
 	}
 
-	articles, err := ah.Storage.GetArticles(params)
+	articles, err := ah.Storage.GetArticles(r.Context(), params)
 	if err != nil {
-		fmt.Println("error with get articles from db", r.URL.Path, err)
+		logger.ErrorContext(r.Context(), "get articles error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -133,13 +139,13 @@ func (ah *ArticleHandler) ShowAll(w http.ResponseWriter, r *http.Request) {
 
 func (ah *ArticleHandler) ShowArticle(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(mux.Vars(r)["id"])
-	article, err := ah.Storage.GetArticleWithID(id)
+	article, err := ah.Storage.GetArticleWithID(r.Context(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			utils.SendErrMessage(w, r, "bad id, no data", http.StatusBadRequest)
 			return
 		}
-		log.Printf("get article with id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get article with id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -155,7 +161,7 @@ func (ah *ArticleHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := ah.SessionManager.IdFromSessionContext(r)
 	if err != nil {
-		log.Printf("get user id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -181,24 +187,29 @@ func (ah *ArticleHandler) Update(w http.ResponseWriter, r *http.Request) {
 		articleFromReq.Slug = translit.Ru(articleFromReq.Title)
 	}
 
-	err = ah.Storage.Update(articleFromReq, userID)
+	userID, ok := ah.ownerIDForModerator(w, r, articleFromReq.ID, userID)
+	if !ok {
+		return
+	}
+
+	err = ah.Storage.Update(r.Context(), articleFromReq, userID)
 	if err != nil {
 		if err == ah.Storage.GetErrNoUpdate() {
 			utils.SendErrMessage(w, r, "no article data to update", http.StatusBadRequest)
 			return
 		}
-		log.Printf("update article data error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "update article data error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	article, err := ah.Storage.GetArticleWithID(articleFromReq.ID)
+	article, err := ah.Storage.GetArticleWithID(r.Context(), articleFromReq.ID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			utils.SendErrMessage(w, r, "bad id, nothing to update", http.StatusBadRequest)
 			return
 		}
-		log.Printf("get updated article error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get updated article error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -215,7 +226,7 @@ func (ah *ArticleHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := ah.SessionManager.IdFromSessionContext(r)
 	if err != nil {
-		log.Printf("get user id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -235,10 +246,37 @@ func (ah *ArticleHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = ah.Storage.Delete(articleFromReq.ID, userID)
+	userID, ok := ah.ownerIDForModerator(w, r, articleFromReq.ID, userID)
+	if !ok {
+		return
+	}
+
+	err = ah.Storage.Delete(r.Context(), articleFromReq.ID, userID)
 	if err != nil {
-		log.Printf("delete article error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "delete article error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
+
+// ownerIDForModerator returns the id of the article author when the session
+// may moderate articles, so that storage ownership checks pass for other authors.
+// For everybody else the session user id is returned unchanged.
+func (ah *ArticleHandler) ownerIDForModerator(w http.ResponseWriter, r *http.Request, articleID, userID int) (int, bool) {
+	if !ah.SessionManager.HasPermission(r, rbac.PermArticlesModerate) {
+		return userID, true
+	}
+
+	article, err := ah.Storage.GetArticleWithID(r.Context(), articleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "bad id, no data", http.StatusBadRequest)
+			return 0, false
+		}
+		logger.ErrorContext(r.Context(), "get article with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return 0, false
+	}
+
+	return article.Author.ID, true
+}
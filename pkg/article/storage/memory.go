@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/article"
+	"rwa/pkg/user"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Authors gives the username and image of the author, as the join with users does in Postgres.
+type Authors interface {
+	GetUserWithID(ctx context.Context, id int) (*user.User, error)
+}
+
+// MemoryStorage keeps articles in the process, everything is lost on restart.
+// Articles of users unknown to Authors are not returned.
+type MemoryStorage struct {
+	Authors Authors
+
+	mu       sync.Mutex
+	lastID   int
+	articles map[int]*article.Article
+}
+
+func NewMemoryStorage(authors Authors) *MemoryStorage {
+	return &MemoryStorage{
+		Authors:  authors,
+		articles: make(map[int]*article.Article),
+	}
+}
+
+func (st *MemoryStorage) GetErrNoUpdate() error {
+	return errNoUpdate
+}
+
+// copyArticle returns the article as it is read from the table: description and body are never nil.
+func copyArticle(a *article.Article) *article.Article {
+	c := *a
+	c.Author = &article.Author{ID: a.Author.ID}
+	c.Description = new(string)
+	if a.Description != nil {
+		*c.Description = *a.Description
+	}
+	c.Body = new(string)
+	if a.Body != nil {
+		*c.Body = *a.Body
+	}
+	c.TagList = append([]string(nil), a.TagList...)
+	return &c
+}
+
+// withAuthor fills the author of a copy of the article, it returns sql.ErrNoRows
+// if the author is gone.
+func (st *MemoryStorage) withAuthor(ctx context.Context, a *article.Article) (*article.Article, error) {
+	author, err := st.Authors.GetUserWithID(ctx, a.Author.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := copyArticle(a)
+	c.Author.Username = author.Username
+	if author.Image != nil {
+		c.Author.Image = *author.Image
+	}
+	return c, nil
+}
+
+func (st *MemoryStorage) Add(ctx context.Context, new *article.Article) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.lastID++
+	saved := copyArticle(new)
+	saved.ID = st.lastID
+	st.articles[saved.ID] = saved
+
+	return saved.ID, nil
+}
+
+// Update changes the article only if it belongs to the user.
+func (st *MemoryStorage) Update(ctx context.Context, update *article.Article, userID int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if update.Body == nil && update.Description == nil && update.Title == "" && update.Slug == "" && update.TagList == nil {
+		return st.GetErrNoUpdate()
+	}
+	update.UpdatedAt = time.Now()
+
+	saved, ok := st.articles[update.ID]
+	if !ok || saved.Author.ID != userID {
+		return nil
+	}
+
+	if update.Body != nil {
+		*saved.Body = *update.Body
+	}
+	if update.Description != nil {
+		*saved.Description = *update.Description
+	}
+	if update.Title != "" {
+		saved.Title = update.Title
+	}
+	if update.Slug != "" {
+		saved.Slug = update.Slug
+	}
+	if update.TagList != nil {
+		saved.TagList = append([]string(nil), update.TagList...)
+	}
+	saved.UpdatedAt = update.UpdatedAt
+	return nil
+}
+
+// Delete removes the article only if it belongs to the user.
+func (st *MemoryStorage) Delete(ctx context.Context, articleID, userID int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	saved, ok := st.articles[articleID]
+	if ok && saved.Author.ID == userID {
+		delete(st.articles, articleID)
+	}
+	return nil
+}
+
+// DeleteAll removes every article of the user, it is called when the user is deleted.
+func (st *MemoryStorage) DeleteAll(ctx context.Context, userID int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, saved := range st.articles {
+		if saved.Author.ID == userID {
+			delete(st.articles, id)
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) GetArticles(ctx context.Context, filters map[string]string) ([]*article.Article, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	author, byAuthor := filters["author"]
+	tag, byTag := filters["tag"]
+
+	articles := []*article.Article{}
+	for _, saved := range st.articles {
+		a, err := st.withAuthor(ctx, saved)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if byAuthor {
+			if a.Author.Username != author {
+				continue
+			}
+		} else if byTag && !hasTag(a.TagList, tag) {
+			continue
+		}
+		articles = append(articles, a)
+	}
+
+	sort.Slice(articles, func(i, j int) bool {
+		return articles[i].ID < articles[j].ID
+	})
+	return articles, nil
+}
+
+func hasTag(tagList []string, tag string) bool {
+	for _, t := range tagList {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *MemoryStorage) GetArticleWithID(ctx context.Context, id int) (*article.Article, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	saved, ok := st.articles[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return st.withAuthor(ctx, saved)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"rwa/pkg/article"
+	"rwa/pkg/sqlite"
+	"time"
+)
+
+// SQLiteStorage keeps articles in SQLite, tag lists are JSON arrays.
+type SQLiteStorage struct {
+	*Storage
+}
+
+func NewSQLiteStorage(db *sql.DB) *SQLiteStorage {
+	return &SQLiteStorage{
+		Storage: NewStorage(db),
+	}
+}
+
+const sqliteArticleColumns = "u.username, u.image, a.id, a.user_id, a.title, a.slug, a.description, a.body, a.tag_list, a.created_at, a.updated_at FROM users u JOIN articles a ON u.id = a.user_id"
+
+func (st *SQLiteStorage) Add(ctx context.Context, new *article.Article) (int, error) {
+	var lastInsertId int
+
+	err := st.db.QueryRowContext(ctx, `INSERT INTO
+	articles(user_id,title,slug,description,body,tag_list,created_at,updated_at)
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8)
+	RETURNING id`,
+		new.Author.ID, new.Title, new.Slug, new.Description, new.Body, sqlite.StringArray(new.TagList), new.CreatedAt, new.UpdatedAt,
+	).Scan(&lastInsertId)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if lastInsertId == 0 {
+		return 0, fmt.Errorf("no last insert id")
+	}
+
+	return lastInsertId, nil
+}
+
+func (st *SQLiteStorage) Update(ctx context.Context, article *article.Article, userID int) error {
+
+	query := "UPDATE articles SET "
+	placeholderNum := 1
+	args := make([]interface{}, 0)
+
+	if article.Body != nil {
+		query += fmt.Sprintf("body = $%v, ", placeholderNum)
+		placeholderNum++
+		args = append(args, *article.Body)
+	}
+
+	if article.Description != nil {
+		query += fmt.Sprintf("description = $%v, ", placeholderNum)
+		placeholderNum++
+		args = append(args, *article.Description)
+	}
+
+	if article.Title != "" {
+		query += fmt.Sprintf("title = $%v, ", placeholderNum)
+		placeholderNum++
+		args = append(args, article.Title)
+	}
+
+	if article.Slug != "" {
+		query += fmt.Sprintf("slug = $%v, ", placeholderNum)
+		placeholderNum++
+		args = append(args, article.Slug)
+	}
+
+	if article.TagList != nil {
+		query += fmt.Sprintf("tag_list = $%v, ", placeholderNum)
+		placeholderNum++
+		args = append(args, sqlite.StringArray(article.TagList))
+	}
+
+	if placeholderNum == 1 {
+		return st.GetErrNoUpdate()
+	}
+	article.UpdatedAt = time.Now()
+	query += fmt.Sprintf("updated_at = $%v WHERE id = $%v and user_id = $%v", placeholderNum, placeholderNum+1, placeholderNum+2)
+
+	args = append(args, article.UpdatedAt, article.ID, userID)
+
+	_, err := st.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *SQLiteStorage) GetArticles(ctx context.Context, filters map[string]string) ([]*article.Article, error) {
+	articles := []*article.Article{}
+	query := "SELECT " + sqliteArticleColumns
+
+	var rows *sql.Rows
+	var err error
+	if author, ok := filters["author"]; ok {
+		query += " WHERE u.username = $1"
+		rows, err = st.db.QueryContext(ctx, query, author)
+
+	} else if tag, ok := filters["tag"]; ok {
+		query += " WHERE EXISTS (SELECT 1 FROM json_each(a.tag_list) WHERE value = $1)"
+		rows, err = st.db.QueryContext(ctx, query, tag)
+
+	} else {
+		rows, err = st.db.QueryContext(ctx, query)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanSQLiteArticle(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+
+	return articles, rows.Err()
+}
+
+func (st *SQLiteStorage) GetArticleWithID(ctx context.Context, id int) (*article.Article, error) {
+	row := st.db.QueryRowContext(ctx, "SELECT "+sqliteArticleColumns+" WHERE a.id=$1", id)
+	return scanSQLiteArticle(row)
+}
+
+// scanner is a *sql.Row or *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLiteArticle(row scanner) (*article.Article, error) {
+	a := &article.Article{Author: &article.Author{}}
+	var bodySQL, descriptionSQL, imageSQL sql.NullString
+	var tagList sqlite.StringArray
+
+	err := row.Scan(
+		&a.Author.Username,
+		&imageSQL,
+		&a.ID,
+		&a.Author.ID,
+		&a.Title,
+		&a.Slug,
+		&descriptionSQL,
+		&bodySQL,
+		&tagList,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Author.Image = imageSQL.String
+	a.Description = &descriptionSQL.String
+	a.Body = &bodySQL.String
+	a.TagList = tagList
+	return a, nil
+}
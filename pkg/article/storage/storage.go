@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -26,18 +27,17 @@ func (st *Storage) GetErrNoUpdate() error {
 	return errNoUpdate
 }
 
-func (st *Storage) GetAuthorWithID(id string) (string, string, error) {
+func (st *Storage) GetAuthorWithID(ctx context.Context, id string) (string, string, error) {
 	var author, bio string
 
-	err := st.db.QueryRow("SELECT username, bio FROM users WHERE id = $1", id).Scan(&author, &bio)
+	err := st.db.QueryRowContext(ctx, "SELECT username, bio FROM users WHERE id = $1", id).Scan(&author, &bio)
 	if err != nil {
 		return "", "", err
 	}
-	fmt.Println("AuthoR:", author)
 	return author, bio, nil
 }
 
-func (st *Storage) Add(new *article.Article) (int, error) {
+func (st *Storage) Add(ctx context.Context, new *article.Article) (int, error) {
 	var lastInsertId int
 
 	var descriptionSQL, bodySQL sql.NullString
@@ -56,7 +56,7 @@ func (st *Storage) Add(new *article.Article) (int, error) {
 		bodySQL.Valid = true
 	}
 
-	err := st.db.QueryRow(`INSERT INTO 
+	err := st.db.QueryRowContext(ctx, `INSERT INTO 
 	articles(user_id,title,slug,description,body,tag_list,created_at,updated_at) 
 	VALUES($1,$2,$3,$4,$5,$6,$7,$8) 
 	RETURNING id`,
@@ -74,7 +74,7 @@ func (st *Storage) Add(new *article.Article) (int, error) {
 	return lastInsertId, nil
 }
 
-func (st *Storage) Update(article *article.Article, userID int) error {
+func (st *Storage) Update(ctx context.Context, article *article.Article, userID int) error {
 
 	query := "UPDATE articles SET "
 	placeholderNum := 1
@@ -118,22 +118,22 @@ func (st *Storage) Update(article *article.Article, userID int) error {
 
 	args = append(args, article.UpdatedAt, article.ID, userID)
 
-	_, err := st.db.Exec(query, args...)
+	_, err := st.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (st *Storage) Delete(articleID, userID int) error {
-	_, err := st.db.Exec("DELETE FROM articles WHERE id = $1 and user_id = $2", articleID, userID)
+func (st *Storage) Delete(ctx context.Context, articleID, userID int) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM articles WHERE id = $1 and user_id = $2", articleID, userID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (st *Storage) GetArticles(filters map[string]string) ([]*article.Article, error) {
+func (st *Storage) GetArticles(ctx context.Context, filters map[string]string) ([]*article.Article, error) {
 	articles := []*article.Article{}
 	query := "SELECT u.username, u.image, a.id ,a.user_id, a.title, a.slug, a.description, a.body, a.tag_list, a.created_at, a.updated_at FROM users u JOIN articles a ON u.id = a.user_id"
 
@@ -141,14 +141,14 @@ func (st *Storage) GetArticles(filters map[string]string) ([]*article.Article, e
 	var err error
 	if author, ok := filters["author"]; ok {
 		query += " WHERE users.username = $1"
-		rows, err = st.db.Query(query, author)
+		rows, err = st.db.QueryContext(ctx, query, author)
 
 	} else if tag, ok := filters["tag"]; ok {
 		query += " WHERE $1 = ANY(tag_list)"
-		rows, err = st.db.Query(query, tag)
+		rows, err = st.db.QueryContext(ctx, query, tag)
 
 	} else {
-		rows, err = st.db.Query(query)
+		rows, err = st.db.QueryContext(ctx, query)
 	}
 
 	if err != nil {
@@ -214,7 +214,7 @@ func (st *Storage) GetArticles(filters map[string]string) ([]*article.Article, e
 	return articles, nil
 }
 
-func (st *Storage) GetArticleWithID(id int) (*article.Article, error) {
+func (st *Storage) GetArticleWithID(ctx context.Context, id int) (*article.Article, error) {
 
 	var userID int
 	var username, slug, title string
@@ -223,7 +223,7 @@ func (st *Storage) GetArticleWithID(id int) (*article.Article, error) {
 	var createdAt, updatedAt time.Time
 
 	err := st.db.
-		QueryRow("SELECT u.username, u.image, a.user_id, a.title, a.slug, a.description, a.body, a.tag_list, a.created_at, a.updated_at FROM users u JOIN articles a ON u.id = a.user_id WHERE a.id=$1", id).
+		QueryRowContext(ctx, "SELECT u.username, u.image, a.user_id, a.title, a.slug, a.description, a.body, a.tag_list, a.created_at, a.updated_at FROM users u JOIN articles a ON u.id = a.user_id WHERE a.id=$1", id).
 		Scan(
 			&username,
 			&imageSQL,
@@ -0,0 +1,52 @@
+package article
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) Add(ctx context.Context, new *Article) (int, error) {
+	ctx, span := tracing.Start(ctx, "article.Storage.Add")
+	result, err := s.Storage.Add(ctx, new)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) Update(ctx context.Context, article *Article, userID int) error {
+	ctx, span := tracing.Start(ctx, "article.Storage.Update")
+	err := s.Storage.Update(ctx, article, userID)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) Delete(ctx context.Context, articleID, userID int) error {
+	ctx, span := tracing.Start(ctx, "article.Storage.Delete")
+	err := s.Storage.Delete(ctx, articleID, userID)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) GetArticles(ctx context.Context, filters map[string]string) ([]*Article, error) {
+	ctx, span := tracing.Start(ctx, "article.Storage.GetArticles")
+	result, err := s.Storage.GetArticles(ctx, filters)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) GetArticleWithID(ctx context.Context, id int) (*Article, error) {
+	ctx, span := tracing.Start(ctx, "article.Storage.GetArticleWithID")
+	result, err := s.Storage.GetArticleWithID(ctx, id)
+	tracing.End(span, err)
+	return result, err
+}
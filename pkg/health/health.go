@@ -0,0 +1,150 @@
+// Package health answers the probes of the orchestrator. /healthz tells the
+// process is alive, /readyz tells it can serve requests: its dependencies answer
+// and it is not shutting down.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"rwa/migration"
+	"rwa/pkg/logging"
+	"sync/atomic"
+	"time"
+)
+
+var logger = logging.For("health")
+
+// Check returns an error if the dependency can not be used.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Health struct {
+	// Timeout bounds the checks of one readiness probe.
+	Timeout time.Duration
+
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func NewHealth(timeout time.Duration) *Health {
+	return &Health{
+		Timeout: timeout,
+	}
+}
+
+// Add adds a check run by every readiness probe.
+func (h *Health) Add(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// ShutDown makes the readiness probe fail, so the load balancer stops sending
+// requests before the server stops taking them.
+func (h *Health) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Live answers the liveness probe: the process serves HTTP.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	send(w, r, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// Ready answers the readiness probe with the result of every check,
+// 503 if one of them fails or the server is shutting down.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		send(w, r, http.StatusServiceUnavailable, map[string]interface{}{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+	defer cancel()
+
+	code := http.StatusOK
+	status := "ok"
+	checks := map[string]string{}
+	for _, c := range h.checks {
+		err := c.check(ctx)
+		if err != nil {
+			logger.WarnContext(ctx, "readiness check failed", "check", c.name, "error", err)
+			code = http.StatusServiceUnavailable
+			status = "unavailable"
+			checks[c.name] = err.Error()
+			continue
+		}
+		checks[c.name] = "ok"
+	}
+
+	send(w, r, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+// MigrationsApplied fails while the migrator knows migrations not applied to the
+// database, the storages would query tables and columns that are not there.
+func MigrationsApplied(migrator *migration.Migrator) Check {
+	return func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d schema migrations are not applied", pending)
+		}
+		return nil
+	}
+}
+
+func send(w http.ResponseWriter, r *http.Request, code int, response map[string]interface{}) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "marshal response error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// the answer of a probe is never cached
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+// maxRetryDelay is the longest wait between two attempts of Retry.
+const maxRetryDelay = 30 * time.Second
+
+// Retry runs check until it passes, waiting between the attempts twice as long as
+// before, from a second up to maxRetryDelay. It gives up with the last error when
+// timeout passes or ctx is canceled.
+func Retry(ctx context.Context, name string, timeout time.Duration, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := check(ctx)
+		if err == nil {
+			if attempt > 1 {
+				logger.InfoContext(ctx, "dependency is up", "check", name, "attempts", attempt)
+			}
+			return nil
+		}
+
+		deadline, _ := ctx.Deadline()
+		if time.Until(deadline) < delay {
+			return fmt.Errorf("%s is not available after %d attempts: %w", name, attempt, err)
+		}
+		logger.WarnContext(ctx, "dependency is not available, retrying",
+			"check", name, "attempt", attempt, "retry_in", delay.String(), "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%s is not available after %d attempts: %w", name, attempt, errors.Join(err, ctx.Err()))
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
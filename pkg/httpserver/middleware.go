@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"rwa/pkg/utils"
+	"strconv"
+	"time"
+)
+
+// Recover answers a request whose handler panicked with 500 and the error
+// envelope of the API, and logs the panic with the stack. The server keeps
+// serving other requests. It must be inside logging.Middleware, so the record
+// carries the request ID.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// the handler aborts the response on purpose, net/http does not log it
+				panic(v)
+			}
+
+			logger.ErrorContext(r.Context(), "panic serving request",
+				"panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			if rw.wroteHeader {
+				// the response is started, the client gets it cut
+				panic(http.ErrAbortHandler)
+			}
+			utils.SendErrMessage(rw, r, "internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// SecurityHeaders sets the headers that keep browsers from sniffing the content
+// type, framing the responses and leaking the URL in Referer. hsts is the max-age
+// of Strict-Transport-Security, 0 does not send it: it is only sent when the API
+// is reached over HTTPS.
+func SecurityHeaders(hsts time.Duration) func(http.Handler) http.Handler {
+	hstsValue := "max-age=" + strconv.Itoa(int(hsts.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "no-referrer")
+			if hsts > 0 {
+				header.Set("Strict-Transport-Security", hstsValue)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
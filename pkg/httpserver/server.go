@@ -0,0 +1,107 @@
+// Package httpserver runs the HTTP servers of the API: the timeouts and limits
+// of the connections, TLS, the serve errors and the graceful shutdown.
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"rwa/pkg/logging"
+	"time"
+)
+
+var logger = logging.For("httpserver")
+
+// Limits bound the time and the size of what a client sends.
+type Limits struct {
+	// ReadHeaderTimeout is the time to read the request headers, it stops clients
+	// holding connections open by sending the headers slowly.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is the time to read the whole request, with the body.
+	ReadTimeout time.Duration
+	// WriteTimeout is the time from the end of the request headers to the end of the response.
+	WriteTimeout time.Duration
+	// IdleTimeout is the time a keep-alive connection waits for the next request.
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+}
+
+type Server struct {
+	*http.Server
+	Name string
+	// CertFile and KeyFile are the PEM files of the certificate, the server
+	// serves HTTPS if they are set.
+	CertFile string
+	KeyFile  string
+}
+
+func NewServer(name string, port int, handler http.Handler, limits Limits) *Server {
+	return &Server{
+		Server: &http.Server{
+			Addr:              fmt.Sprintf(":%d", port),
+			Handler:           handler,
+			ReadHeaderTimeout: limits.ReadHeaderTimeout,
+			ReadTimeout:       limits.ReadTimeout,
+			WriteTimeout:      limits.WriteTimeout,
+			IdleTimeout:       limits.IdleTimeout,
+			MaxHeaderBytes:    limits.MaxHeaderBytes,
+			// TLS handshake and connection errors of net/http go to the log
+			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		},
+		Name: name,
+	}
+}
+
+// Start listens on the port and serves the connections in the background. An error
+// of listening, such as a busy port, or of loading the certificate is returned at
+// once, an error of serving is sent to errs.
+func (s *Server) Start(errs chan<- error) error {
+	if s.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load %s server certificate: %w", s.Name, err)
+		}
+		s.TLSConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{certificate},
+		}
+	}
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listen %s server: %w", s.Name, err)
+	}
+	logger.Info("start server", "server", s.Name, "addr", s.Addr, "tls", s.TLSConfig != nil)
+
+	go func() {
+		var err error
+		if s.TLSConfig != nil {
+			// the certificate is in TLSConfig already
+			err = s.ServeTLS(listener, "", "")
+		} else {
+			err = s.Serve(listener)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs <- fmt.Errorf("%s server: %w", s.Name, err)
+		}
+	}()
+	return nil
+}
+
+// Stop stops taking connections and waits for the requests in flight to be served.
+// The connections left after timeout are closed.
+func (s *Server) Stop(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.Warn("requests are not served in time, closing connections", "server", s.Name, "timeout", timeout.String())
+		return s.Close()
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package lockout
+
+import (
+	"context"
+	"rwa/pkg/logging"
+	"strings"
+	"time"
+)
+
+var logger = logging.For("lockout")
+
+// Guard tracks failed login attempts per account and per client IP.
+// Every failure makes the key wait an exponentially growing delay before the
+// next attempt, MaxFailures failures in a row lock the key for Lockout.
+type Guard struct {
+	Storage  Storage
+	Notifier Notifier
+	Account  Policy
+	IP       Policy
+}
+
+type Policy struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lockout     time.Duration
+	// Window is how long a failure is remembered, an older counter starts over.
+	Window time.Duration
+}
+
+type Storage interface {
+	Get(ctx context.Context, key string) (*Attempts, error)
+	// AddAttempt counts an attempt unless the key is blocked, in one atomic step.
+	// It returns the counter after the attempt, or the block that refused it.
+	AddAttempt(ctx context.Context, key string, window time.Duration) (*Attempts, error)
+	RemoveAttempt(ctx context.Context, key string) error
+	Block(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	DeleteStale(ctx context.Context, idle time.Duration) error
+}
+
+// Notifier tells the account owner that the account was locked.
+type Notifier interface {
+	NotifyLockout(ctx context.Context, email string, until time.Time) error
+}
+
+type Attempts struct {
+	Failures     int
+	BlockedUntil time.Time
+}
+
+func NewGuard(storage Storage, notifier Notifier, account, ip Policy) *Guard {
+	return &Guard{
+		Storage:  storage,
+		Notifier: notifier,
+		Account:  account,
+		IP:       ip,
+	}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the client has to wait before the next login attempt.
+// A permitted attempt is counted as a failure right away, so parallel requests
+// cannot all pass the check before the first of them fails.
+// Keys are tracked for any email, so the answer is the same whether the account exists or not.
+func (g *Guard) Check(ctx context.Context, email, ip string) (time.Duration, error) {
+	wait, err := g.attempt(ctx, ipKey(ip), g.IP)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	return g.attempt(ctx, accountKey(email), g.Account)
+}
+
+func (g *Guard) attempt(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	attempts, err := g.Storage.AddAttempt(ctx, key, policy.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if wait := attempts.BlockedUntil.Sub(now); wait > 0 {
+		return wait, nil
+	}
+	if attempts.Failures > policy.MaxFailures {
+		// the attempts in flight used up the limit before the key got blocked
+		return policy.Lockout, g.Storage.Block(ctx, key, now.Add(policy.Lockout))
+	}
+	return 0, nil
+}
+
+// Fail blocks the keys of an attempt counted by Check for the delay of its failure.
+// When this failure locks the account it returns the time the account is locked until,
+// otherwise zero time.
+func (g *Guard) Fail(ctx context.Context, email, ip string) (time.Time, error) {
+	_, err := g.fail(ctx, ipKey(ip), g.IP)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return g.fail(ctx, accountKey(email), g.Account)
+}
+
+func (g *Guard) fail(ctx context.Context, key string, policy Policy) (time.Time, error) {
+	attempts, err := g.Storage.Get(ctx, key)
+	if err != nil || attempts == nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	if attempts.Failures >= policy.MaxFailures {
+		until := now.Add(policy.Lockout)
+		err = g.Storage.Block(ctx, key, until)
+		if err != nil || attempts.Failures > policy.MaxFailures {
+			return time.Time{}, err
+		}
+		return until, nil
+	}
+
+	return time.Time{}, g.Storage.Block(ctx, key, now.Add(policy.delay(attempts.Failures)))
+}
+
+func (p Policy) delay(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// Success resets the failures of the account and takes back the attempt Check counted for the IP.
+// The rest of the IP counter is left as is, so one valid account does not unlock guessing others.
+func (g *Guard) Success(ctx context.Context, email, ip string) error {
+	err := g.Storage.RemoveAttempt(ctx, ipKey(ip))
+	if err != nil {
+		return err
+	}
+	return g.Unlock(ctx, email)
+}
+
+// Unlock resets the failures of the account.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.Storage.Reset(ctx, accountKey(email))
+}
+
+// NotifyLockout sends the notification in the background,
+// so the response time does not depend on the account existence.
+// The notification outlives the request, it keeps only the values of ctx.
+func (g *Guard) NotifyLockout(ctx context.Context, email string, until time.Time) {
+	if g.Notifier == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		err := g.Notifier.NotifyLockout(ctx, email, until)
+		if err != nil {
+			logger.ErrorContext(ctx, "lockout notification error", "error", err)
+		}
+	}()
+}
+
+func (g *Guard) Sweep(interval time.Duration, done <-chan struct{}) {
+	idle := g.Account.Window + g.Account.Lockout
+	if ipIdle := g.IP.Window + g.IP.Lockout; ipIdle > idle {
+		idle = ipIdle
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := g.Storage.DeleteStale(context.Background(), idle)
+			if err != nil {
+				logger.Error("login attempts sweep error", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"rwa/pkg/lockout"
+	"sync"
+	"time"
+)
+
+type attempts struct {
+	failures      int
+	lastFailureAt time.Time
+	blockedUntil  time.Time
+}
+
+// MemoryStorage keeps failed login attempts in the process, they are not
+// shared between instances and are lost on restart.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	attempts map[string]*attempts
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		attempts: make(map[string]*attempts),
+	}
+}
+
+func (st *MemoryStorage) Get(ctx context.Context, key string) (*lockout.Attempts, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	a, ok := st.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	return &lockout.Attempts{
+		Failures:     a.failures,
+		BlockedUntil: a.blockedUntil,
+	}, nil
+}
+
+func (st *MemoryStorage) AddAttempt(ctx context.Context, key string, window time.Duration) (*lockout.Attempts, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	a, ok := st.attempts[key]
+	if !ok {
+		a = &attempts{}
+		st.attempts[key] = a
+	}
+	if a.blockedUntil.After(now) {
+		return &lockout.Attempts{
+			Failures:     a.failures,
+			BlockedUntil: a.blockedUntil,
+		}, nil
+	}
+
+	if a.lastFailureAt.Before(now.Add(-window)) {
+		a.failures = 1
+	} else {
+		a.failures++
+	}
+	a.lastFailureAt = now
+	return &lockout.Attempts{
+		Failures: a.failures,
+	}, nil
+}
+
+func (st *MemoryStorage) RemoveAttempt(ctx context.Context, key string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if a, ok := st.attempts[key]; ok && a.failures > 0 {
+		a.failures--
+	}
+	return nil
+}
+
+func (st *MemoryStorage) Block(ctx context.Context, key string, until time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if a, ok := st.attempts[key]; ok {
+		a.blockedUntil = until
+	}
+	return nil
+}
+
+func (st *MemoryStorage) Reset(ctx context.Context, key string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.attempts, key)
+	return nil
+}
+
+func (st *MemoryStorage) DeleteStale(ctx context.Context, idle time.Duration) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	border := time.Now().Add(-idle)
+	for key, a := range st.attempts {
+		if a.lastFailureAt.Before(border) {
+			delete(st.attempts, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/lockout"
+	"time"
+)
+
+type Storage struct {
+	db *sql.DB
+}
+
+func NewStorage(db *sql.DB) *Storage {
+	return &Storage{
+		db: db,
+	}
+}
+
+func (st *Storage) Get(ctx context.Context, key string) (*lockout.Attempts, error) {
+	var failures int
+	var blockedUntil sql.NullTime
+
+	err := st.db.QueryRowContext(ctx, "SELECT failures, blocked_until FROM login_attempts WHERE key = $1", key).Scan(&failures, &blockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &lockout.Attempts{
+		Failures:     failures,
+		BlockedUntil: blockedUntil.Time,
+	}, nil
+}
+
+// AddAttempt leaves a blocked key untouched, the UPSERT then returns no row
+// and the block that refused the attempt is read separately.
+func (st *Storage) AddAttempt(ctx context.Context, key string, window time.Duration) (*lockout.Attempts, error) {
+	var failures int
+	now := time.Now()
+
+	err := st.db.QueryRowContext(ctx, `INSERT INTO login_attempts AS la (key, failures, last_failure_at)
+	VALUES ($1, 1, $2)
+	ON CONFLICT (key) DO UPDATE SET
+		failures = CASE WHEN la.last_failure_at < $3 THEN 1 ELSE la.failures + 1 END,
+		last_failure_at = $2
+	WHERE la.blocked_until IS NULL OR la.blocked_until <= $2
+	RETURNING failures`,
+		key, now, now.Add(-window),
+	).Scan(&failures)
+	if err == sql.ErrNoRows {
+		attempts, err := st.Get(ctx, key)
+		if err != nil || attempts != nil {
+			return attempts, err
+		}
+		// the key was reset in between, the block is gone with it
+		return &lockout.Attempts{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockout.Attempts{
+		Failures: failures,
+	}, nil
+}
+
+func (st *Storage) RemoveAttempt(ctx context.Context, key string) error {
+	_, err := st.db.ExecContext(ctx, "UPDATE login_attempts SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) Block(ctx context.Context, key string, until time.Time) error {
+	_, err := st.db.ExecContext(ctx, "UPDATE login_attempts SET blocked_until = $1 WHERE key = $2", until, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) Reset(ctx context.Context, key string) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) DeleteStale(ctx context.Context, idle time.Duration) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE last_failure_at < $1", time.Now().Add(-idle))
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"rwa/migration"
+	"rwa/pkg/lockout"
+	"rwa/pkg/sqlite"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = lockout.Policy{
+	MaxFailures: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+	Lockout:     time.Hour,
+	Window:      time.Hour,
+}
+
+func newTestSQLiteStorage(t *testing.T) *Storage {
+	t.Helper()
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migration.NewMigrator(db, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStorage(db)
+}
+
+func testStorages(t *testing.T) map[string]lockout.Storage {
+	return map[string]lockout.Storage{
+		"memory": NewMemoryStorage(),
+		"sqlite": newTestSQLiteStorage(t),
+	}
+}
+
+// TestCheckParallel sends more parallel attempts than the limit,
+// no more than MaxFailures of them may pass the check.
+func TestCheckParallel(t *testing.T) {
+	for name, st := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			guard := lockout.NewGuard(st, nil, testPolicy, lockout.Policy{
+				MaxFailures: 100,
+				BaseDelay:   time.Second,
+				MaxDelay:    time.Minute,
+				Lockout:     time.Hour,
+				Window:      time.Hour,
+			})
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			passed := 0
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					wait, err := guard.Check(context.Background(), "user@example.com", "192.0.2.1")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if wait == 0 {
+						mu.Lock()
+						passed++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if passed > testPolicy.MaxFailures {
+				t.Fatalf("%d attempts passed the check, the limit is %d", passed, testPolicy.MaxFailures)
+			}
+			wait, err := guard.Check(context.Background(), "user@example.com", "192.0.2.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait <= 0 {
+				t.Fatal("the account is not locked after the limit")
+			}
+		})
+	}
+}
+
+func TestCheckFailSuccess(t *testing.T) {
+	for name, st := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			guard := lockout.NewGuard(st, nil, testPolicy, testPolicy)
+			ctx := context.Background()
+			email, ip := "user@example.com", "192.0.2.1"
+
+			wait, err := guard.Check(ctx, email, ip)
+			if err != nil || wait != 0 {
+				t.Fatalf("first check: wait %v, error %v", wait, err)
+			}
+			until, err := guard.Fail(ctx, email, ip)
+			if err != nil || !until.IsZero() {
+				t.Fatalf("first failure: until %v, error %v", until, err)
+			}
+
+			// the delay of the failure refuses the next attempt
+			wait, err = guard.Check(ctx, email, ip)
+			if err != nil || wait <= 0 || wait > testPolicy.BaseDelay {
+				t.Fatalf("check after failure: wait %v, error %v", wait, err)
+			}
+
+			// a success takes back the attempt of the ip and unlocks the account
+			err = st.Block(ctx, "ip:"+ip, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = st.Block(ctx, "account:"+email, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wait, err = guard.Check(ctx, email, ip)
+			if err != nil || wait != 0 {
+				t.Fatalf("check after delay: wait %v, error %v", wait, err)
+			}
+			err = guard.Success(ctx, email, ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			attempts, err := st.Get(ctx, "ip:"+ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts == nil || attempts.Failures != 1 {
+				t.Fatalf("ip attempts after success: %+v", attempts)
+			}
+			attempts, err = st.Get(ctx, "account:"+email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attempts != nil {
+				t.Fatalf("account attempts after success: %+v", attempts)
+			}
+		})
+	}
+}
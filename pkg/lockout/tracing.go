@@ -0,0 +1,60 @@
+package lockout
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+	"time"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) Get(ctx context.Context, key string) (*Attempts, error) {
+	ctx, span := tracing.Start(ctx, "lockout.Storage.Get")
+	result, err := s.Storage.Get(ctx, key)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) AddAttempt(ctx context.Context, key string, window time.Duration) (*Attempts, error) {
+	ctx, span := tracing.Start(ctx, "lockout.Storage.AddAttempt")
+	result, err := s.Storage.AddAttempt(ctx, key, window)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) RemoveAttempt(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "lockout.Storage.RemoveAttempt")
+	err := s.Storage.RemoveAttempt(ctx, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) Block(ctx context.Context, key string, until time.Time) error {
+	ctx, span := tracing.Start(ctx, "lockout.Storage.Block")
+	err := s.Storage.Block(ctx, key, until)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) Reset(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "lockout.Storage.Reset")
+	err := s.Storage.Reset(ctx, key)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) DeleteStale(ctx context.Context, idle time.Duration) error {
+	ctx, span := tracing.Start(ctx, "lockout.Storage.DeleteStale")
+	err := s.Storage.DeleteStale(ctx, idle)
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,149 @@
+// Package logging writes structured logs with log/slog. Every package takes its
+// logger with For, the output and the levels are set once in main with Setup, so
+// loggers made before Setup follow it too. Records logged with a context carry the
+// attributes of the request, the request ID first of all, see WithAttrs, and the
+// IDs of the trace and the span, so a log record can be found in the trace.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type setup struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+var current atomic.Pointer[setup]
+
+func init() {
+	current.Store(&setup{
+		handler: slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		level:   slog.LevelInfo,
+	})
+}
+
+// Setup makes every logger write to w in the format, json or text. A package logs
+// records of level and above, unless levels has its own level for the package.
+func Setup(w io.Writer, format string, level slog.Level, levels map[string]slog.Level) error {
+	// the level is checked by the package handlers, the output handler passes everything
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	switch format {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return fmt.Errorf("unknown log format: [%s]", format)
+	}
+
+	current.Store(&setup{
+		handler: handler,
+		level:   level,
+		levels:  levels,
+	})
+	// the log package and libraries using slog.Default log through the main logger
+	slog.SetDefault(For("main"))
+	return nil
+}
+
+// For returns the logger of the package, its records have a "pkg" attribute.
+func For(pkg string) *slog.Logger {
+	return slog.New(&handler{pkg: pkg})
+}
+
+// handler checks the level of the package and passes records to the handler
+// of the current setup, adding the attributes of the context.
+type handler struct {
+	pkg string
+	// with are the WithAttrs and WithGroup calls, replayed on the current handler
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	s := current.Load()
+	min, ok := s.levels[h.pkg]
+	if !ok {
+		min = s.level
+	}
+	return level >= min
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	out := current.Load().handler.WithAttrs([]slog.Attr{slog.String("pkg", h.pkg)})
+	if attrs := attrsFromContext(ctx); len(attrs) > 0 {
+		out = out.WithAttrs(attrs)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		out = out.WithAttrs([]slog.Attr{
+			slog.String("trace_id", span.TraceID().String()),
+			slog.String("span_id", span.SpanID().String()),
+		})
+	}
+	for _, with := range h.with {
+		out = with(out)
+	}
+	return out.Handle(ctx, record)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.add(func(out slog.Handler) slog.Handler {
+		return out.WithAttrs(attrs)
+	})
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.add(func(out slog.Handler) slog.Handler {
+		return out.WithGroup(name)
+	})
+}
+
+func (h *handler) add(with func(slog.Handler) slog.Handler) *handler {
+	return &handler{
+		pkg:  h.pkg,
+		with: append(h.with[:len(h.with):len(h.with)], with),
+	}
+}
+
+type ctxKey struct{}
+
+// WithAttrs returns a context whose records carry attrs besides the ones
+// of the parent context.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent := attrsFromContext(ctx)
+	all := make([]slog.Attr, 0, len(parent)+len(attrs))
+	all = append(all, parent...)
+	all = append(all, attrs...)
+	return context.WithValue(ctx, ctxKey{}, all)
+}
+
+func attrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs
+}
+
+// RequestID returns the ID of the request the context belongs to, or "".
+func RequestID(ctx context.Context) string {
+	for _, attr := range attrsFromContext(ctx) {
+		if attr.Key == requestIDKey {
+			return attr.Value.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"rwa/pkg/logging"
+	"strings"
+	"time"
+)
+
+var logger = logging.For("mail")
+
+type Message struct {
+	ID      int
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Outbox is a Mailer that only stores messages, Dispatch delivers them later
+// through a transport, so a slow or broken mail server does not fail requests.
+type Outbox struct {
+	Storage Storage
+}
+
+type Storage interface {
+	Add(ctx context.Context, msg *Message) error
+	// Claim returns up to limit messages due for delivery and hides them
+	// from other instances for lease.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*Message, error)
+	MarkSent(ctx context.Context, id int) error
+	MarkFailed(ctx context.Context, id int, reason string, retryAt time.Time) error
+}
+
+func NewOutbox(storage Storage) *Outbox {
+	return &Outbox{
+		Storage: storage,
+	}
+}
+
+func (o *Outbox) Send(ctx context.Context, msg *Message) error {
+	return o.Storage.Add(ctx, msg)
+}
+
+// Dispatch sends pending messages through the transport every interval
+// until the done channel is closed.
+func (o *Outbox) Dispatch(transport Mailer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			o.dispatchBatch(transport)
+		}
+	}
+}
+
+func (o *Outbox) dispatchBatch(transport Mailer) {
+	ctx := context.Background()
+	messages, err := o.Storage.Claim(ctx, 20, time.Minute)
+	if err != nil {
+		logger.Error("claim outbox messages error", "error", err)
+		return
+	}
+
+	for _, msg := range messages {
+		err = transport.Send(ctx, msg)
+		if err != nil {
+			logger.Warn("send mail error", "error", err, "message_id", msg.ID)
+			err = o.Storage.MarkFailed(ctx, msg.ID, err.Error(), time.Now().Add(5*time.Minute))
+		} else {
+			logger.Debug("mail sent", "message_id", msg.ID)
+			err = o.Storage.MarkSent(ctx, msg.ID)
+		}
+		if err != nil {
+			logger.Error("update outbox message error", "error", err, "message_id", msg.ID)
+		}
+	}
+}
+
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		Addr: host + ":" + port,
+		From: from,
+		Auth: auth,
+	}
+}
+
+func (m *SMTPMailer) Send(_ context.Context, msg *Message) error {
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// FileMailer writes every message to a separate .eml file in Dir,
+// it lets the mail flows work locally without a mail server.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{
+		Dir:  dir,
+		From: from,
+	}, nil
+}
+
+func (m *FileMailer) Send(_ context.Context, msg *Message) error {
+	name := fmt.Sprintf("%s_%d.eml", time.Now().Format("20060102T150405.000000000"), msg.ID)
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644)
+}
+
+func format(from string, msg *Message) []byte {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "From: %s\r\n", from)
+	fmt.Fprintf(b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"rwa/pkg/mail"
+	"sort"
+	"sync"
+	"time"
+)
+
+type outboxMessage struct {
+	msg           mail.Message
+	attempts      int
+	lastError     string
+	nextAttemptAt time.Time
+	sent          bool
+}
+
+// MemoryStorage keeps the outbox in the process, unsent messages are lost on restart.
+// Sent messages are dropped at once.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	lastID   int
+	messages map[int]*outboxMessage
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		messages: make(map[int]*outboxMessage),
+	}
+}
+
+func (st *MemoryStorage) Add(ctx context.Context, msg *mail.Message) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.lastID++
+	msg.ID = st.lastID
+	st.messages[msg.ID] = &outboxMessage{
+		msg:           *msg,
+		nextAttemptAt: time.Now(),
+	}
+	return nil
+}
+
+func (st *MemoryStorage) Claim(ctx context.Context, limit int, lease time.Duration) ([]*mail.Message, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	ids := []int{}
+	for id, m := range st.messages {
+		if !m.sent && !m.nextAttemptAt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	messages := []*mail.Message{}
+	for _, id := range ids {
+		m := st.messages[id]
+		m.nextAttemptAt = now.Add(lease)
+		msg := m.msg
+		messages = append(messages, &msg)
+	}
+	return messages, nil
+}
+
+func (st *MemoryStorage) MarkSent(ctx context.Context, id int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.messages, id)
+	return nil
+}
+
+func (st *MemoryStorage) MarkFailed(ctx context.Context, id int, reason string, retryAt time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if m, ok := st.messages[id]; ok {
+		m.attempts++
+		m.lastError = reason
+		m.nextAttemptAt = retryAt
+	}
+	return nil
+}
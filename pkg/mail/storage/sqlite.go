@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/mail"
+	"time"
+)
+
+// SQLiteStorage keeps the outbox in SQLite. SQLite has a single writer,
+// so Claim needs no row locks.
+type SQLiteStorage struct {
+	*Storage
+}
+
+func NewSQLiteStorage(db *sql.DB) *SQLiteStorage {
+	return &SQLiteStorage{
+		Storage: NewStorage(db),
+	}
+}
+
+func (st *SQLiteStorage) Claim(ctx context.Context, limit int, lease time.Duration) ([]*mail.Message, error) {
+	now := time.Now()
+	rows, err := st.db.QueryContext(ctx, `UPDATE mail_outbox SET next_attempt_at = $1
+	WHERE id IN (
+		SELECT id FROM mail_outbox
+		WHERE sent_at IS NULL AND next_attempt_at <= $2
+		ORDER BY id
+		LIMIT $3
+	)
+	RETURNING id, recipient, subject, body`,
+		now.Add(lease), now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*mail.Message{}
+	for rows.Next() {
+		msg := &mail.Message{}
+		err = rows.Scan(&msg.ID, &msg.To, &msg.Subject, &msg.Body)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
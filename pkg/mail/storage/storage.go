@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/mail"
+	"time"
+)
+
+type Storage struct {
+	db *sql.DB
+}
+
+func NewStorage(db *sql.DB) *Storage {
+	return &Storage{
+		db: db,
+	}
+}
+
+func (st *Storage) Add(ctx context.Context, msg *mail.Message) error {
+	now := time.Now()
+	err := st.db.QueryRowContext(ctx, "INSERT INTO mail_outbox(recipient,subject,body,created_at,next_attempt_at) VALUES($1,$2,$3,$4,$4) RETURNING id",
+		msg.To, msg.Subject, msg.Body, now,
+	).Scan(&msg.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) Claim(ctx context.Context, limit int, lease time.Duration) ([]*mail.Message, error) {
+	now := time.Now()
+	rows, err := st.db.QueryContext(ctx, `UPDATE mail_outbox SET next_attempt_at = $1
+	WHERE id IN (
+		SELECT id FROM mail_outbox
+		WHERE sent_at IS NULL AND next_attempt_at <= $2
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, recipient, subject, body`,
+		now.Add(lease), now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*mail.Message{}
+	for rows.Next() {
+		msg := &mail.Message{}
+		err = rows.Scan(&msg.ID, &msg.To, &msg.Subject, &msg.Body)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (st *Storage) MarkSent(ctx context.Context, id int) error {
+	_, err := st.db.ExecContext(ctx, "UPDATE mail_outbox SET sent_at = $1, last_error = NULL WHERE id = $2", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) MarkFailed(ctx context.Context, id int, reason string, retryAt time.Time) error {
+	_, err := st.db.ExecContext(ctx, "UPDATE mail_outbox SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2 WHERE id = $3", reason, retryAt, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
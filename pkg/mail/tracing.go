@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+	"time"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) Add(ctx context.Context, msg *Message) error {
+	ctx, span := tracing.Start(ctx, "mail.Storage.Add")
+	err := s.Storage.Add(ctx, msg)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Message, error) {
+	ctx, span := tracing.Start(ctx, "mail.Storage.Claim")
+	result, err := s.Storage.Claim(ctx, limit, lease)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) MarkSent(ctx context.Context, id int) error {
+	ctx, span := tracing.Start(ctx, "mail.Storage.MarkSent")
+	err := s.Storage.MarkSent(ctx, id)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) MarkFailed(ctx context.Context, id int, reason string, retryAt time.Time) error {
+	ctx, span := tracing.Start(ctx, "mail.Storage.MarkFailed")
+	err := s.Storage.MarkFailed(ctx, id, reason, retryAt)
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,106 @@
+// Package metrics keeps the Prometheus metrics of the API. Packages update the
+// metrics declared here, Handler exposes them on the admin port.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "rwa"
+
+// Registry holds the metrics of the API, the Go runtime and the process.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time of serving requests by route template, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "code"})
+
+	HTTPRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "Requests being served.",
+	})
+
+	// SessionCacheChecks counts session checks answered by the cache (hit) and
+	// passed to the storage (miss).
+	SessionCacheChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "session_cache",
+		Name:      "checks_total",
+		Help:      "Session checks by result: hit or miss.",
+	}, []string{"result"})
+
+	SessionCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "session_cache",
+		Name:      "entries",
+		Help:      "Session keys in the cache.",
+	})
+
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "user",
+		Name:      "registrations_total",
+		Help:      "Registered users, including the ones registered by an OAuth login.",
+	})
+
+	// Logins counts finished logins: a password login of a user with two-factor
+	// authentication is counted once the code is checked.
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "user",
+		Name:      "logins_total",
+		Help:      "Logins by result: success or failure.",
+	}, []string{"result"})
+
+	ArticlesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "article",
+		Name:      "created_total",
+		Help:      "Created articles.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		HTTPRequestsInFlight,
+		SessionCacheChecks,
+		SessionCacheEntries,
+		Registrations,
+		Logins,
+		ArticlesCreated,
+	)
+
+	// the series are shown at zero before the first event
+	for _, result := range []string{"hit", "miss"} {
+		SessionCacheChecks.WithLabelValues(result)
+	}
+	for _, result := range []string{"success", "failure"} {
+		Logins.WithLabelValues(result)
+	}
+}
+
+// RegisterDB exposes the connection pool stats of the database as go_sql_* gauges
+// and counters labeled with db_name.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,205 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrUnknownProvider = errors.New("unknown oauth provider")
+var ErrBadState = errors.New("bad or expired oauth state")
+
+// Providers runs the OpenID Connect authorization code flow with PKCE.
+// The state, the PKCE verifier and the nonce of a started login are kept in
+// Storage, so the callback may be served by any instance.
+type Providers struct {
+	Storage   Storage
+	Providers map[string]*Provider
+	StateTTL  time.Duration
+}
+
+type Storage interface {
+	AddState(ctx context.Context, state *State) error
+	// UseState returns the state once and deletes it, ErrBadState if it is unknown or expired.
+	UseState(ctx context.Context, state string) (*State, error)
+}
+
+type State struct {
+	State     string
+	Provider  string
+	Verifier  string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	mu       sync.Mutex
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Identity is what the provider tells about the signed in user.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+func NewProviders(storage Storage, stateTTL time.Duration, providers ...*Provider) *Providers {
+	p := &Providers{
+		Storage:   storage,
+		Providers: make(map[string]*Provider),
+		StateTTL:  stateTTL,
+	}
+	for _, provider := range providers {
+		p.Providers[provider.Name] = provider
+	}
+	return p
+}
+
+// discover loads the provider metadata on first use, so the API starts even
+// when a provider is down. A failed discovery is retried on the next login.
+func (pr *Provider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.config != nil {
+		return pr.config, pr.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, pr.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes := append([]string{oidc.ScopeOpenID}, pr.Scopes...)
+	pr.config = &oauth2.Config{
+		ClientID:     pr.ClientID,
+		ClientSecret: pr.ClientSecret,
+		RedirectURL:  pr.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	pr.verifier = provider.Verifier(&oidc.Config{ClientID: pr.ClientID})
+
+	return pr.config, pr.verifier, nil
+}
+
+// AuthURL starts a login with the provider and returns the address to redirect the
+// user to and the state of the login, which the callback gets back from the provider.
+func (p *Providers) AuthURL(ctx context.Context, name string) (string, string, error) {
+	provider, ok := p.Providers[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	config, _, err := provider.discover(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("discover provider %s: %w", name, err)
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	err = p.Storage.AddState(ctx, &State{
+		State:     state,
+		Provider:  name,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: time.Now().Add(p.StateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce)), state, nil
+}
+
+// Exchange finishes the login: it spends the state, exchanges the code and verifies the ID token.
+func (p *Providers) Exchange(ctx context.Context, name, state, code string) (*Identity, error) {
+	provider, ok := p.Providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	saved, err := p.Storage.UseState(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	if saved.Provider != name {
+		return nil, ErrBadState
+	}
+
+	config, verifier, err := provider.discover(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("discover provider %s: %w", name, err)
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(saved.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("no id_token in token response")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id_token: %w", err)
+	}
+	if idToken.Nonce != saved.Nonce {
+		return nil, fmt.Errorf("id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Provider:      name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"rwa/pkg/oauth"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps started logins in the process, they are lost on restart.
+type MemoryStorage struct {
+	mu     sync.Mutex
+	states map[string]*oauth.State
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		states: make(map[string]*oauth.State),
+	}
+}
+
+func (st *MemoryStorage) AddState(ctx context.Context, state *oauth.State) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	saved := *state
+	st.states[state.State] = &saved
+
+	// only started logins are needed, expired ones are dropped on the way
+	now := time.Now()
+	for key, s := range st.states {
+		if s.ExpiresAt.Before(now) {
+			delete(st.states, key)
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) UseState(ctx context.Context, state string) (*oauth.State, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	saved, ok := st.states[state]
+	if !ok {
+		return nil, oauth.ErrBadState
+	}
+	delete(st.states, state)
+
+	if time.Now().After(saved.ExpiresAt) {
+		return nil, oauth.ErrBadState
+	}
+	return saved, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/oauth"
+	"time"
+)
+
+type Storage struct {
+	db *sql.DB
+}
+
+func NewStorage(db *sql.DB) *Storage {
+	return &Storage{
+		db: db,
+	}
+}
+
+func (st *Storage) AddState(ctx context.Context, state *oauth.State) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO oauth_states(state,provider,verifier,nonce,expires_at) VALUES($1,$2,$3,$4,$5)",
+		state.State, state.Provider, state.Verifier, state.Nonce, state.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// the table only needs started logins, expired ones are dropped on the way
+	_, err = st.db.ExecContext(ctx, "DELETE FROM oauth_states WHERE expires_at < $1", time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) UseState(ctx context.Context, state string) (*oauth.State, error) {
+	saved := &oauth.State{}
+	err := st.db.QueryRowContext(ctx, "DELETE FROM oauth_states WHERE state = $1 RETURNING state, provider, verifier, nonce, expires_at", state).
+		Scan(&saved.State, &saved.Provider, &saved.Verifier, &saved.Nonce, &saved.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, oauth.ErrBadState
+		}
+		return nil, err
+	}
+
+	if time.Now().After(saved.ExpiresAt) {
+		return nil, oauth.ErrBadState
+	}
+	return saved, nil
+}
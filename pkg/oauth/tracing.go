@@ -0,0 +1,31 @@
+package oauth
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) AddState(ctx context.Context, state *State) error {
+	ctx, span := tracing.Start(ctx, "oauth.Storage.AddState")
+	err := s.Storage.AddState(ctx, state)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) UseState(ctx context.Context, state string) (*State, error) {
+	ctx, span := tracing.Start(ctx, "oauth.Storage.UseState")
+	result, err := s.Storage.UseState(ctx, state)
+	tracing.End(span, err)
+	return result, err
+}
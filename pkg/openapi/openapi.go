@@ -0,0 +1,138 @@
+// Package openapi keeps the OpenAPI 3.1 document of the API. The document is
+// embedded in the binary and served with a docs UI, the request bodies can be
+// validated against its schemas with Validator.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rwa/pkg/logging"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/swaggest/swgui/v5emb"
+)
+
+var logger = logging.For("openapi")
+
+const (
+	SpecPath = "/api/openapi.json"
+	DocsPath = "/api/docs/"
+)
+
+//go:embed openapi.json
+var document []byte
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Handler serves the document.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+}
+
+// DocsHandler serves Swagger UI showing the document, the UI files are
+// embedded in the binary as well.
+func DocsHandler() http.Handler {
+	return v5emb.New("API-Articles", SpecPath, strings.TrimSuffix(DocsPath, "/"))
+}
+
+// PathTemplate turns a route template of gorilla/mux into a path of the document:
+// /api/articles/{id:[0-9]+} is /api/articles/{id}.
+func PathTemplate(template string) string {
+	var b strings.Builder
+	depth := 0
+	skip := false
+	for _, c := range template {
+		switch {
+		case c == '{':
+			depth++
+			if depth > 1 {
+				// a brace of the pattern, such as {id:[0-9]{2}}
+				continue
+			}
+		case c == '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			skip = false
+		case c == ':' && depth == 1:
+			skip = true
+		}
+		if !skip {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// operations returns the methods of every path of the document, in lower case.
+func operations() (map[string]map[string]json.RawMessage, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err := json.Unmarshal(document, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("parse openapi document: %w", err)
+	}
+
+	result := make(map[string]map[string]json.RawMessage, len(doc.Paths))
+	for path, item := range doc.Paths {
+		result[path] = map[string]json.RawMessage{}
+		for _, method := range methods {
+			if operation, ok := item[method]; ok {
+				result[path][method] = operation
+			}
+		}
+	}
+	return result, nil
+}
+
+// CheckRoutes returns an error naming every route of the router that has no
+// operation in the document, so a route is not added without describing it.
+func CheckRoutes(router *mux.Router) error {
+	paths, err := operations()
+	if err != nil {
+		return err
+	}
+
+	missing := []string{}
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			// a route without a path matches the ones of its subrouter
+			return nil
+		}
+		path := PathTemplate(template)
+
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			// a route for any method needs the path at least
+			if len(paths[path]) == 0 {
+				missing = append(missing, "* "+path)
+			}
+			return nil
+		}
+		for _, method := range routeMethods {
+			if _, ok := paths[path][strings.ToLower(method)]; !ok {
+				missing = append(missing, method+" "+path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("routes missing in the openapi document: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package openapi
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"/api/articles", "/api/articles"},
+		{"/api/articles/{id}", "/api/articles/{id}"},
+		{"/api/articles/{id:[0-9]+}", "/api/articles/{id}"},
+		{"/api/admin/users/{id:[0-9]+}/roles", "/api/admin/users/{id}/roles"},
+		{"/api/articles/{id:[0-9]{2,4}}", "/api/articles/{id}"},
+		{"/api/{kind:(?:a|b){1}}/{id:[0-9]{2}}/x", "/api/{kind}/{id}/x"},
+	}
+	for _, tt := range tests {
+		got := PathTemplate(tt.template)
+		if got != tt.want {
+			t.Errorf("PathTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	router := mux.NewRouter()
+	router.Handle("/api/articles", handler).Methods(http.MethodGet, http.MethodPost)
+	router.Handle("/api/articles/{id:[0-9]+}", handler).Methods(http.MethodGet)
+	err := CheckRoutes(router)
+	if err != nil {
+		t.Fatalf("documented routes: %v", err)
+	}
+
+	router.Handle("/api/articles/{id:[0-9]+}", handler).Methods(http.MethodPatch)
+	router.Handle("/api/unknown", handler)
+	err = CheckRoutes(router)
+	if err == nil {
+		t.Fatal("undocumented routes: no error")
+	}
+	for _, route := range []string{"PATCH /api/articles/{id}", "* /api/unknown"} {
+		if !strings.Contains(err.Error(), route) {
+			t.Errorf("error %q does not name %s", err, route)
+		}
+	}
+}
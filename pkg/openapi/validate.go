@@ -0,0 +1,156 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"rwa/pkg/utils"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// documentURL is the name of the document among the schema resources,
+// the $refs of the schemas are resolved against it.
+const documentURL = "openapi.json"
+
+var printer = message.NewPrinter(language.English)
+
+// Validator checks the JSON request bodies against the schemas of the operations.
+type Validator struct {
+	// schemas are the request body schemas by "METHOD /path" of the document
+	schemas map[string]*jsonschema.Schema
+}
+
+func NewValidator() (*Validator, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("parse openapi document: %w", err)
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	err = compiler.AddResource(documentURL, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := operations()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Validator{schemas: map[string]*jsonschema.Schema{}}
+	for path, operations := range paths {
+		for method, raw := range operations {
+			var operation struct {
+				RequestBody *struct {
+					Content map[string]json.RawMessage `json:"content"`
+				} `json:"requestBody"`
+			}
+			err = json.Unmarshal(raw, &operation)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s %s: %w", method, path, err)
+			}
+			if operation.RequestBody == nil || operation.RequestBody.Content["application/json"] == nil {
+				continue
+			}
+
+			location := documentURL + "#/paths/" + pointerEscape(path) + "/" + method +
+				"/requestBody/content/application~1json/schema"
+			schema, err := compiler.Compile(location)
+			if err != nil {
+				return nil, fmt.Errorf("compile request schema of %s %s: %w", method, path, err)
+			}
+			v.schemas[strings.ToUpper(method)+" "+path] = schema
+		}
+	}
+	return v, nil
+}
+
+// Middleware answers 400 with the error envelope when the body of a request does
+// not match the schema of its operation. It is a router middleware: the operation
+// is found by the matched route template. Requests of routes without a request
+// body schema are passed on as they are.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		schema, ok := v.schemas[r.Method+" "+PathTemplate(template)]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "read body error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// the handler reads the body again
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = validate(schema, body)
+		if err != nil {
+			logger.DebugContext(r.Context(), "invalid request body", "error", err)
+			utils.SendErrMessage(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validate(schema *jsonschema.Schema, body []byte) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return fmt.Errorf("request body must be not empty")
+	}
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("request body is not valid json")
+	}
+
+	err = schema.Validate(instance)
+	var validationErr *jsonschema.ValidationError
+	if errors.As(err, &validationErr) {
+		return fmt.Errorf("request body does not match the schema: %s", describe(validationErr))
+	}
+	return err
+}
+
+// describe lists the failed keywords with the locations in the body, such as
+// "/user/email: minLength: got 0, want 1".
+func describe(err *jsonschema.ValidationError) string {
+	messages := []string{}
+	var walk func(err *jsonschema.ValidationError)
+	walk = func(err *jsonschema.ValidationError) {
+		// the errors above the leaves only say that a subschema failed
+		if len(err.Causes) == 0 {
+			messages = append(messages, "/"+strings.Join(err.InstanceLocation, "/")+": "+err.ErrorKind.LocalizedString(printer))
+		}
+		for _, cause := range err.Causes {
+			walk(cause)
+		}
+	}
+	walk(err)
+	return strings.Join(messages, "; ")
+}
+
+// pointerEscape escapes a key of the document for a JSON pointer.
+func pointerEscape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,248 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"rwa/pkg/logging"
+	"rwa/pkg/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var logger = logging.For("ratelimit")
+
+const (
+	KeyIP    = "ip"
+	KeyUser  = "user"
+	KeyRoute = "route"
+)
+
+type Limiter struct {
+	Storage        Storage
+	Rules          []Rule
+	SessionManager SessionManager
+}
+
+func NewLimiter(storage Storage, rules []Rule, sessionManager SessionManager) *Limiter {
+	return &Limiter{
+		Storage:        storage,
+		Rules:          rules,
+		SessionManager: sessionManager,
+	}
+}
+
+// Storage keeps token buckets. Take refills the bucket for the elapsed time,
+// removes one token if there is one and reports the tokens left.
+type Storage interface {
+	Take(ctx context.Context, key string, capacity int, period time.Duration) (*Result, error)
+	DeleteStale(ctx context.Context, idle time.Duration) error
+}
+
+type SessionManager interface {
+	IdFromSessionContext(r *http.Request) (int, error)
+}
+
+type Result struct {
+	Allowed   bool
+	Remaining float64
+}
+
+// Rule limits requests to the routes of a group to Limit requests per Period.
+// Path is a mux route template, a trailing "*" matches any template with the prefix.
+type Rule struct {
+	Group  string
+	Method string
+	Path   string
+	Limit  int
+	Period time.Duration
+	Key    string
+}
+
+func (rl *Rule) matches(method, path string) bool {
+	if rl.Method != "*" && rl.Method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(rl.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return rl.Path == path
+}
+
+// perSecond is the bucket refill rate.
+func (rl *Rule) perSecond() float64 {
+	return float64(rl.Limit) / rl.Period.Seconds()
+}
+
+// ParseRules parses rules separated by ";", each written as
+// "<group> <method> <path> <limit>/<period> <ip|user|route>",
+// for example "login POST /api/users/login 5/1m ip".
+func ParseRules(s string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, raw := range strings.Split(s, ";") {
+		fields := strings.Fields(raw)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("rate limit rule %q: want 5 fields, got %d", raw, len(fields))
+		}
+
+		limitStr, periodStr, ok := strings.Cut(fields[3], "/")
+		if !ok {
+			return nil, fmt.Errorf("rate limit rule %q: bad rate %q", raw, fields[3])
+		}
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("rate limit rule %q: bad limit %q", raw, limitStr)
+		}
+		period, err := time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("rate limit rule %q: bad period %q", raw, periodStr)
+		}
+
+		key := fields[4]
+		if key != KeyIP && key != KeyUser && key != KeyRoute {
+			return nil, fmt.Errorf("rate limit rule %q: bad key %q", raw, key)
+		}
+
+		rules = append(rules, Rule{
+			Group:  fields[0],
+			Method: strings.ToUpper(fields[1]),
+			Path:   fields[2],
+			Limit:  limit,
+			Period: period,
+			Key:    key,
+		})
+	}
+	return rules, nil
+}
+
+// findRule returns the first rule with one of the keys that matches the request.
+func (l *Limiter) findRule(r *http.Request, keys ...string) *Rule {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return nil
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return nil
+	}
+
+	for i := range l.Rules {
+		if slices.Contains(keys, l.Rules[i].Key) && l.Rules[i].matches(r.Method, path) {
+			return &l.Rules[i]
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) bucketKey(r *http.Request, rule *Rule) string {
+	switch rule.Key {
+	case KeyRoute:
+		return rule.Group
+	case KeyUser:
+		if l.SessionManager != nil {
+			if id, err := l.SessionManager.IdFromSessionContext(r); err == nil {
+				return rule.Group + ":user:" + strconv.Itoa(id)
+			}
+		}
+	}
+	return rule.Group + ":ip:" + utils.ClientIP(r)
+}
+
+// IPMiddleware applies the ip and route keyed rules. It must run before the auth
+// middleware, so that requests with bad credentials are limited too and do not
+// cost a session lookup.
+func (l *Limiter) IPMiddleware(next http.Handler) http.Handler {
+	return l.middleware(next, KeyIP, KeyRoute)
+}
+
+// UserMiddleware applies the user keyed rules. It must run after the auth
+// middleware so that the rules can see the session.
+func (l *Limiter) UserMiddleware(next http.Handler) http.Handler {
+	return l.middleware(next, KeyUser)
+}
+
+func (l *Limiter) middleware(next http.Handler, keys ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		rule := l.findRule(r, keys...)
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := l.Storage.Take(r.Context(), l.bucketKey(r, rule), rule.Limit, rule.Period)
+		if err != nil {
+			// the limiter must not take the API down with its storage
+			logger.ErrorContext(r.Context(), "rate limit storage error", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rate := rule.perSecond()
+		reset := math.Ceil((float64(rule.Limit) - result.Remaining) / rate)
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(rule.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(result.Remaining)))))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit, int(rule.Period.Seconds())))
+
+		if !result.Allowed {
+			retryAfter := math.Max(1, math.Ceil((1-result.Remaining)/rate))
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+			utils.SendErrMessage(w, r, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxPeriod is the longest period of the rules. A bucket left alone for a period
+// is full again, so deleting it after the longest one does not reset a limit.
+func (l *Limiter) maxPeriod() time.Duration {
+	var max time.Duration
+	for _, rule := range l.Rules {
+		if rule.Period > max {
+			max = rule.Period
+		}
+	}
+	return max
+}
+
+// Sweep deletes buckets not touched for the longest rule period until the done
+// channel is closed.
+func (l *Limiter) Sweep(interval time.Duration, done <-chan struct{}) {
+	idle := l.maxPeriod()
+	if idle == 0 {
+		// no rules, no buckets
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := l.Storage.DeleteStale(context.Background(), idle)
+			if err != nil {
+				logger.Error("rate limit sweep error", "error", err)
+			}
+		}
+	}
+}
+
+// Refill returns the tokens in a bucket of the capacity after elapsed time.
+func Refill(tokens float64, elapsed time.Duration, capacity int, period time.Duration) float64 {
+	tokens += elapsed.Seconds() * float64(capacity) / period.Seconds()
+	return math.Min(tokens, float64(capacity))
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"rwa/pkg/ratelimit"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryStorage keeps buckets in the process, limits are not shared between instances.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (st *MemoryStorage) Take(ctx context.Context, key string, capacity int, period time.Duration) (*ratelimit.Result, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	b, ok := st.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(capacity), updatedAt: now}
+		st.buckets[key] = b
+	}
+
+	b.tokens = ratelimit.Refill(b.tokens, now.Sub(b.updatedAt), capacity, period)
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return &ratelimit.Result{Allowed: false, Remaining: b.tokens}, nil
+	}
+	b.tokens--
+
+	return &ratelimit.Result{Allowed: true, Remaining: b.tokens}, nil
+}
+
+func (st *MemoryStorage) DeleteStale(ctx context.Context, idle time.Duration) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	border := time.Now().Add(-idle)
+	for key, b := range st.buckets {
+		if b.updatedAt.Before(border) {
+			delete(st.buckets, key)
+		}
+	}
+	return nil
+}
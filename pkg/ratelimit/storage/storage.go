@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"rwa/pkg/ratelimit"
+	"strings"
+	"time"
+)
+
+// refilled is the token count of an existing bucket after the refill,
+// $2 is the bucket capacity and $3 the refill rate per second.
+const refilled = "LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM (now() - rl.updated_at))::float8 * $3::float8)"
+
+var takeQuery = strings.ReplaceAll(`INSERT INTO rate_limits AS rl (key, tokens, allowed, updated_at)
+	VALUES ($1, $2::float8 - 1, true, now())
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = CASE WHEN {refilled} >= 1 THEN {refilled} - 1 ELSE {refilled} END,
+		allowed = {refilled} >= 1,
+		updated_at = now()
+	RETURNING tokens, allowed`, "{refilled}", refilled)
+
+// Storage keeps buckets in Postgres so that instances of the API share the limits.
+// A bucket is refilled and taken from in a single statement.
+type Storage struct {
+	db *sql.DB
+}
+
+func NewStorage(db *sql.DB) *Storage {
+	return &Storage{
+		db: db,
+	}
+}
+
+func (st *Storage) Take(ctx context.Context, key string, capacity int, period time.Duration) (*ratelimit.Result, error) {
+	result := &ratelimit.Result{}
+	rate := float64(capacity) / period.Seconds()
+
+	err := st.db.QueryRowContext(ctx, takeQuery, key, capacity, rate).Scan(&result.Remaining, &result.Allowed)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (st *Storage) DeleteStale(ctx context.Context, idle time.Duration) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE updated_at < now() - $1::interval", fmt.Sprintf("%d seconds", int(idle.Seconds())))
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package ratelimit
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+	"time"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) Take(ctx context.Context, key string, capacity int, period time.Duration) (*Result, error) {
+	ctx, span := tracing.Start(ctx, "ratelimit.Storage.Take")
+	result, err := s.Storage.Take(ctx, key, capacity, period)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) DeleteStale(ctx context.Context, idle time.Duration) error {
+	ctx, span := tracing.Start(ctx, "ratelimit.Storage.DeleteStale")
+	err := s.Storage.DeleteStale(ctx, idle)
+	tracing.End(span, err)
+	return err
+}
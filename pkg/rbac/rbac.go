@@ -0,0 +1,65 @@
+package rbac
+
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleAuthor    = "author"
+	RoleReader    = "reader"
+)
+
+const (
+	PermArticlesWrite    = "articles:write"
+	PermArticlesModerate = "articles:moderate"
+	PermUserRead         = "user:read"
+	PermUserWrite        = "user:write"
+	PermUsersManage      = "users:manage"
+	// PermCredentialsManage covers sessions and access tokens, it is never given to a token.
+	PermCredentialsManage = "credentials:manage"
+)
+
+// DefaultRoles are given to every newly registered user.
+var DefaultRoles = []string{RoleAuthor}
+
+var rolePermissions = map[string]map[string]struct{}{
+	RoleAdmin: {
+		PermArticlesWrite:     struct{}{},
+		PermArticlesModerate:  struct{}{},
+		PermUserRead:          struct{}{},
+		PermUserWrite:         struct{}{},
+		PermUsersManage:       struct{}{},
+		PermCredentialsManage: struct{}{},
+	},
+	RoleModerator: {
+		PermArticlesWrite:     struct{}{},
+		PermArticlesModerate:  struct{}{},
+		PermUserRead:          struct{}{},
+		PermUserWrite:         struct{}{},
+		PermCredentialsManage: struct{}{},
+	},
+	RoleAuthor: {
+		PermArticlesWrite:     struct{}{},
+		PermUserRead:          struct{}{},
+		PermUserWrite:         struct{}{},
+		PermCredentialsManage: struct{}{},
+	},
+	RoleReader: {
+		PermUserRead:          struct{}{},
+		PermUserWrite:         struct{}{},
+		PermCredentialsManage: struct{}{},
+	},
+}
+
+// Can reports whether any of the roles grants the permission.
+func Can(roles []string, permission string) bool {
+	for _, role := range roles {
+		if _, ok := rolePermissions[role][permission]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
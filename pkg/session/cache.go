@@ -0,0 +1,361 @@
+package session
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"rwa/pkg/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CacheBus carries cache invalidations to the other instances sharing the storage.
+type CacheBus interface {
+	Publish(ctx context.Context, event string) error
+}
+
+// Cache is a Storage that keeps the results of CheckSession in a bounded LRU cache.
+// Known keys are cached for TTL and unknown ones for NegativeTTL, so a session that
+// expires on its own may still be accepted for up to TTL. Deleting sessions invalidates
+// the cache at once, on other instances through the Bus.
+//
+// With Users set the Cache is a UserStorage too: the roles and the verified flag of
+// up to Size users are cached for TTL and dropped with the sessions by the "u:" event,
+// InvalidateUser sends it when they change.
+type Cache struct {
+	Storage     Storage
+	Users       UserStorage
+	Bus         CacheBus
+	Size        int
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	byUser  map[int]map[string]struct{}
+	users   map[int]*userEntry
+	// generation changes on every invalidation, a CheckSession result is not
+	// cached if an invalidation happened while the storage was queried.
+	generation uint64
+}
+
+type cacheEntry struct {
+	key       string
+	userID    int
+	expiresAt time.Time
+}
+
+// userEntry holds what was loaded of the user, nil fields are not cached yet.
+type userEntry struct {
+	roles     *[]string
+	verified  *bool
+	expiresAt time.Time
+}
+
+func NewCache(storage Storage, size int, ttl, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		Storage:     storage,
+		Size:        size,
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		byUser:      make(map[int]map[string]struct{}),
+		users:       make(map[int]*userEntry),
+	}
+}
+
+// cacheKey keeps session keys out of memory dumps and invalidation events.
+func cacheKey(sessionKey string) string {
+	sum := sha256.Sum256([]byte(sessionKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) Create(ctx context.Context, sessionKey string, userID int, info *Info) error {
+	err := c.Storage.Create(ctx, sessionKey, userID, info)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.removeKey(cacheKey(sessionKey))
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Cache) CheckSession(ctx context.Context, sessionKey string) (int, error) {
+	key := cacheKey(sessionKey)
+	now := time.Now()
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if now.Before(entry.expiresAt) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			metrics.SessionCacheChecks.WithLabelValues("hit").Inc()
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("session.cache_hit", true))
+			if entry.userID == 0 {
+				return 0, sql.ErrNoRows
+			}
+			return entry.userID, nil
+		}
+		c.removeElement(elem)
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	metrics.SessionCacheChecks.WithLabelValues("miss").Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("session.cache_hit", false))
+
+	userID, err := c.Storage.CheckSession(ctx, sessionKey)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	ttl := c.TTL
+	if err == sql.ErrNoRows {
+		userID = 0
+		ttl = c.NegativeTTL
+	}
+
+	c.mu.Lock()
+	if generation == c.generation && ttl > 0 {
+		c.add(&cacheEntry{key: key, userID: userID, expiresAt: now.Add(ttl)})
+	}
+	c.mu.Unlock()
+
+	return userID, err
+}
+
+func (c *Cache) List(ctx context.Context, userID int, currentKey string) ([]*Info, error) {
+	return c.Storage.List(ctx, userID, currentKey)
+}
+
+func (c *Cache) Delete(ctx context.Context, sessionKey string) error {
+	err := c.Storage.Delete(ctx, sessionKey)
+	if err != nil {
+		return err
+	}
+
+	key := cacheKey(sessionKey)
+	c.Invalidate("k:" + key)
+	c.publish(ctx, "k:"+key)
+	return nil
+}
+
+func (c *Cache) DeleteWithID(ctx context.Context, userID int, id string) error {
+	err := c.Storage.DeleteWithID(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	// the cache does not know which key has the id, so every key of the user is dropped
+	event := "u:" + strconv.Itoa(userID)
+	c.Invalidate(event)
+	c.publish(ctx, event)
+	return nil
+}
+
+func (c *Cache) DeleteAll(ctx context.Context, userID int) error {
+	err := c.Storage.DeleteAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	event := "u:" + strconv.Itoa(userID)
+	c.Invalidate(event)
+	c.publish(ctx, event)
+	return nil
+}
+
+func (c *Cache) DeleteExpired(ctx context.Context) error {
+	return c.Storage.DeleteExpired(ctx)
+}
+
+func (c *Cache) GetRoles(ctx context.Context, userID int) ([]string, error) {
+	c.mu.Lock()
+	if entry := c.cachedUser(userID); entry != nil && entry.roles != nil {
+		roles := append([]string{}, *entry.roles...)
+		c.mu.Unlock()
+		return roles, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	roles, err := c.Users.GetRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if entry := c.userToAdd(userID, generation); entry != nil {
+		cached := append([]string{}, roles...)
+		entry.roles = &cached
+	}
+	c.mu.Unlock()
+	return roles, nil
+}
+
+func (c *Cache) IsVerified(ctx context.Context, userID int) (bool, error) {
+	c.mu.Lock()
+	if entry := c.cachedUser(userID); entry != nil && entry.verified != nil {
+		verified := *entry.verified
+		c.mu.Unlock()
+		return verified, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	verified, err := c.Users.IsVerified(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	if entry := c.userToAdd(userID, generation); entry != nil {
+		entry.verified = &verified
+	}
+	c.mu.Unlock()
+	return verified, nil
+}
+
+// cachedUser returns the live entry of the user, c.mu must be held.
+func (c *Cache) cachedUser(userID int) *userEntry {
+	entry, ok := c.users[userID]
+	if !ok {
+		return nil
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.users, userID)
+		return nil
+	}
+	return entry
+}
+
+// userToAdd returns the entry to fill with a value loaded at the generation, or nil
+// if the value may be stale or the cache is full. c.mu must be held.
+func (c *Cache) userToAdd(userID int, generation uint64) *userEntry {
+	if generation != c.generation || c.TTL <= 0 {
+		return nil
+	}
+	if entry := c.cachedUser(userID); entry != nil {
+		return entry
+	}
+
+	if len(c.users) >= c.Size {
+		now := time.Now()
+		for id, entry := range c.users {
+			if !now.Before(entry.expiresAt) {
+				delete(c.users, id)
+			}
+		}
+		if len(c.users) >= c.Size {
+			return nil
+		}
+	}
+
+	entry := &userEntry{expiresAt: time.Now().Add(c.TTL)}
+	c.users[userID] = entry
+	return entry
+}
+
+// InvalidateUser drops the sessions, the roles and the verified flag of the user
+// cached here and on other instances, after they were changed in the storage.
+func (c *Cache) InvalidateUser(ctx context.Context, userID int) {
+	event := "u:" + strconv.Itoa(userID)
+	c.Invalidate(event)
+	c.publish(ctx, event)
+}
+
+// publish sends the event to other instances. The session is already deleted in
+// the storage, so a failure is only logged: other instances catch up within TTL.
+func (c *Cache) publish(ctx context.Context, event string) {
+	if c.Bus == nil {
+		return
+	}
+	err := c.Bus.Publish(ctx, event)
+	if err != nil {
+		logger.WarnContext(ctx, "publish session cache invalidation error", "error", err)
+	}
+}
+
+// Invalidate applies an event from Delete, DeleteWithID, DeleteAll or InvalidateUser of
+// any instance: "k:<key>" drops one key and "u:<user id>" drops every key and the
+// cached roles and verified flag of the user.
+func (c *Cache) Invalidate(event string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	switch {
+	case strings.HasPrefix(event, "k:"):
+		c.removeKey(strings.TrimPrefix(event, "k:"))
+	case strings.HasPrefix(event, "u:"):
+		userID, err := strconv.Atoi(strings.TrimPrefix(event, "u:"))
+		if err != nil {
+			return
+		}
+		for key := range c.byUser[userID] {
+			c.removeKey(key)
+		}
+		delete(c.users, userID)
+	}
+}
+
+// Flush drops the whole cache, for example when invalidations could have been missed.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.byUser = make(map[int]map[string]struct{})
+	c.users = make(map[int]*userEntry)
+	metrics.SessionCacheEntries.Set(0)
+}
+
+func (c *Cache) add(entry *cacheEntry) {
+	if elem, ok := c.entries[entry.key]; ok {
+		c.removeElement(elem)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	if entry.userID != 0 {
+		if c.byUser[entry.userID] == nil {
+			c.byUser[entry.userID] = make(map[string]struct{})
+		}
+		c.byUser[entry.userID][entry.key] = struct{}{}
+	}
+
+	for c.lru.Len() > c.Size {
+		c.removeElement(c.lru.Back())
+	}
+	metrics.SessionCacheEntries.Set(float64(c.lru.Len()))
+}
+
+func (c *Cache) removeKey(key string) {
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *Cache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	if keys, ok := c.byUser[entry.userID]; ok {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(c.byUser, entry.userID)
+		}
+	}
+	metrics.SessionCacheEntries.Set(float64(c.lru.Len()))
+}
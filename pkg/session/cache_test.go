@@ -0,0 +1,100 @@
+package session
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// countingUsers counts the loads that got past the cache.
+type countingUsers struct {
+	roles    []string
+	verified bool
+	loads    int
+}
+
+func (u *countingUsers) GetRoles(ctx context.Context, userID int) ([]string, error) {
+	u.loads++
+	return append([]string{}, u.roles...), nil
+}
+
+func (u *countingUsers) IsVerified(ctx context.Context, userID int) (bool, error) {
+	u.loads++
+	return u.verified, nil
+}
+
+func TestCacheUsers(t *testing.T) {
+	ctx := context.Background()
+	users := &countingUsers{roles: []string{"author"}}
+	c := NewCache(nil, 10, time.Minute, time.Second)
+	c.Users = users
+
+	for i := 0; i < 3; i++ {
+		roles, err := c.GetRoles(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(roles, []string{"author"}) {
+			t.Fatalf("roles %v", roles)
+		}
+		verified, err := c.IsVerified(ctx, 1)
+		if err != nil || verified {
+			t.Fatalf("verified %v, error %v", verified, err)
+		}
+	}
+	if users.loads != 2 {
+		t.Fatalf("%d loads, want 2", users.loads)
+	}
+
+	// a change made through the storage drops the cached values
+	users.roles = []string{"admin"}
+	users.verified = true
+	c.InvalidateUser(ctx, 1)
+
+	roles, err := c.GetRoles(ctx, 1)
+	if err != nil || !slices.Equal(roles, []string{"admin"}) {
+		t.Fatalf("roles %v, error %v", roles, err)
+	}
+	verified, err := c.IsVerified(ctx, 1)
+	if err != nil || !verified {
+		t.Fatalf("verified %v, error %v", verified, err)
+	}
+
+	// the event of another instance does the same
+	users.roles = []string{"reader"}
+	c.Invalidate("u:1")
+	roles, err = c.GetRoles(ctx, 1)
+	if err != nil || !slices.Equal(roles, []string{"reader"}) {
+		t.Fatalf("roles %v, error %v", roles, err)
+	}
+}
+
+func TestCacheUsersSize(t *testing.T) {
+	ctx := context.Background()
+	users := &countingUsers{roles: []string{"author"}}
+	c := NewCache(nil, 2, time.Minute, time.Second)
+	c.Users = users
+
+	for id := 1; id <= 3; id++ {
+		_, err := c.GetRoles(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.users) != 2 {
+		t.Fatalf("%d users cached, want 2", len(c.users))
+	}
+
+	// the user over the size is loaded every time
+	users.loads = 0
+	for i := 0; i < 2; i++ {
+		_, err := c.GetRoles(ctx, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if users.loads != 2 {
+		t.Fatalf("%d loads, want 2", users.loads)
+	}
+}
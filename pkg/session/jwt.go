@@ -0,0 +1,335 @@
+package session
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"rwa/pkg/apitoken"
+	"rwa/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Manager is implemented by SessionHandler and JWTManager, so the session backend
+// can be chosen in config.
+type Manager interface {
+	Create(w http.ResponseWriter, r *http.Request, userID int) error
+	Delete(r *http.Request) error
+	DeleteAll(r *http.Request) error
+	DeleteAllWithID(ctx context.Context, userID int) error
+	AuthMiddleware(next http.Handler) http.Handler
+	IdFromSessionContext(r *http.Request) (int, error)
+	HasPermission(r *http.Request, permission string) bool
+	RequirePermission(permission string) func(http.Handler) http.Handler
+	List(w http.ResponseWriter, r *http.Request)
+	Revoke(w http.ResponseWriter, r *http.Request)
+	Sweep(interval time.Duration, done <-chan struct{})
+}
+
+// JWTManager authenticates requests with signed access tokens, without a storage lookup.
+// Refresh tokens are regular sessions of the embedded SessionHandler, so their
+// lifetime, listing and revocation work as for the database backend.
+// A revoked refresh token stops issuing access tokens, already issued ones live until they expire.
+type JWTManager struct {
+	*SessionHandler
+	// Keys verify access tokens by the kid header, SigningKey signs new ones.
+	// Keep a retired key in Keys until the tokens it signed expire.
+	Keys       map[string]*SigningKey
+	SigningKey *SigningKey
+	AccessTTL  time.Duration
+}
+
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+}
+
+type accessClaims struct {
+	// SessionID is the public id of the refresh session the token was issued for.
+	SessionID string   `json:"sid"`
+	Roles     []string `json:"roles"`
+	Verified  bool     `json:"verified"`
+	jwt.RegisteredClaims
+}
+
+func NewJWTManager(sh *SessionHandler, keys map[string]*SigningKey, signingKID string, accessTTL time.Duration) (*JWTManager, error) {
+	signingKey, ok := keys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("no jwt key with kid %q", signingKID)
+	}
+
+	return &JWTManager{
+		SessionHandler: sh,
+		Keys:           keys,
+		SigningKey:     signingKey,
+		AccessTTL:      accessTTL,
+	}, nil
+}
+
+// minHS256SecretLen is the shortest HS256 secret taken, as long as the SHA-256 output.
+const minHS256SecretLen = 32
+
+// ParseSigningKeys parses keys separated by ";", each written as "<kid>:<alg>:<key>".
+// The key of HS256 is the secret itself, at least 32 bytes long, the key of EdDSA
+// is a base64 encoded ed25519 seed.
+func ParseSigningKeys(s string) (map[string]*SigningKey, error) {
+	keys := make(map[string]*SigningKey)
+	for _, raw := range strings.Split(s, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		parts := strings.SplitN(raw, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("jwt key %q: want <kid>:<alg>:<key>", parts[0])
+		}
+
+		key := &SigningKey{ID: parts[0], Algorithm: parts[1]}
+		switch key.Algorithm {
+		case AlgHS256:
+			if len(parts[2]) < minHS256SecretLen {
+				return nil, fmt.Errorf("jwt key %q: want a HS256 secret of at least %d bytes", key.ID, minHS256SecretLen)
+			}
+			key.secret = []byte(parts[2])
+		case AlgEdDSA:
+			seed, err := base64.StdEncoding.DecodeString(parts[2])
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, fmt.Errorf("jwt key %q: want base64 of a %d byte ed25519 seed", key.ID, ed25519.SeedSize)
+			}
+			key.private = ed25519.NewKeyFromSeed(seed)
+		default:
+			return nil, fmt.Errorf("jwt key %q: unknown algorithm %q", key.ID, key.Algorithm)
+		}
+
+		if _, ok := keys[key.ID]; ok {
+			return nil, fmt.Errorf("jwt key %q: duplicate kid", key.ID)
+		}
+		keys[key.ID] = key
+	}
+	return keys, nil
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	if k.Algorithm == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodHS256
+}
+
+func (k *SigningKey) signKey() interface{} {
+	if k.Algorithm == AlgEdDSA {
+		return k.private
+	}
+	return k.secret
+}
+
+func (k *SigningKey) verifyKey() interface{} {
+	if k.Algorithm == AlgEdDSA {
+		return k.private.Public()
+	}
+	return k.secret
+}
+
+// Create starts a refresh session and sends the access token in the Authorization
+// header and the refresh token in the Refresh-Token header.
+func (jm *JWTManager) Create(w http.ResponseWriter, r *http.Request, userID int) error {
+	refreshToken, sessionID, err := jm.newSession(r, userID)
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := jm.issue(r.Context(), userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Authorization", accessToken)
+	w.Header().Set("Refresh-Token", refreshToken)
+	return nil
+}
+
+func (jm *JWTManager) issue(ctx context.Context, userID int, sessionID string) (string, error) {
+	roles, err := jm.UserStorage.GetRoles(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	verified, err := jm.UserStorage.IsVerified(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := &accessClaims{
+		SessionID: sessionID,
+		Roles:     roles,
+		Verified:  verified,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(jm.AccessTTL)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jm.SigningKey.method(), claims)
+	token.Header["kid"] = jm.SigningKey.ID
+
+	return token.SignedString(jm.SigningKey.signKey())
+}
+
+func (jm *JWTManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jm.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("algorithm %q does not match key %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey(), nil
+}
+
+func (jm *JWTManager) Check(r *http.Request) (*Session, error) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if accessToken == "" {
+		return nil, getErrNoAuth()
+	}
+
+	if apitoken.IsToken(accessToken) {
+		return jm.checkToken(r.Context(), accessToken)
+	}
+
+	claims := &accessClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, jm.keyFunc,
+		jwt.WithValidMethods([]string{AlgHS256, AlgEdDSA}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, getErrNoAuth()
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, getErrNoAuth()
+	}
+
+	verified := claims.Verified
+	return &Session{
+		UserID:   userID,
+		ID:       claims.SessionID,
+		Roles:    claims.Roles,
+		Verified: &verified,
+	}, nil
+}
+
+func (jm *JWTManager) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if jm.inWhiteList(r.URL.Path, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := jm.Check(r)
+		if err != nil {
+			if err.Error() == getErrNoAuth().Error() {
+				utils.SendErrMessage(w, r, "no auth", http.StatusUnauthorized)
+				return
+			}
+			logger.ErrorContext(r.Context(), "checking session error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Refresh sends a new access token for the refresh token from the Refresh-Token header.
+func (jm *JWTManager) Refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken := r.Header.Get("Refresh-Token")
+	if refreshToken == "" {
+		utils.SendErrMessage(w, r, "no refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := jm.Storage.CheckSession(r.Context(), refreshToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "no auth", http.StatusUnauthorized)
+			return
+		}
+		logger.ErrorContext(r.Context(), "checking refresh token error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := jm.sessionID(r.Context(), userID, refreshToken)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "no auth", http.StatusUnauthorized)
+			return
+		}
+		logger.ErrorContext(r.Context(), "get refresh session id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, err := jm.issue(r.Context(), userID, sessionID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "issue access token error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Authorization", accessToken)
+}
+
+// sessionID returns the public id of the refresh session, the storage finds
+// sessions by id only among the sessions of the user.
+func (jm *JWTManager) sessionID(ctx context.Context, userID int, refreshToken string) (string, error) {
+	sessions, err := jm.Storage.List(ctx, userID, refreshToken)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range sessions {
+		if info.Current {
+			return info.ID, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+// Delete ends the refresh session the access token was issued for.
+// A session of a personal access token has no refresh session to end.
+func (jm *JWTManager) Delete(r *http.Request) error {
+	session, err := sessionFromContext(r)
+	if err != nil {
+		return err
+	}
+	if session.ID == "" {
+		return nil
+	}
+
+	err = jm.Storage.DeleteWithID(r.Context(), session.UserID, session.ID)
+	if err == sql.ErrNoRows {
+		// the session was already revoked or has expired
+		return nil
+	}
+	return err
+}
@@ -4,39 +4,103 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
+	"rwa/pkg/apitoken"
+	"rwa/pkg/logging"
+	"rwa/pkg/rbac"
 	"rwa/pkg/utils"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 )
 
+var logger = logging.For("session")
+
 type forSession string
 
 const ctxKey forSession = "key"
 
 type SessionHandler struct {
-	Storage   Storage
-	WhiteList map[string]map[string]struct{}
+	Storage     Storage
+	UserStorage UserStorage
+	WhiteList   map[string]map[string]struct{}
+	// UnverifiedDenied are permissions not granted until the user verifies the email.
+	UnverifiedDenied map[string]struct{}
+	// Tokens checks personal access tokens, nil disables them.
+	Tokens TokenStorage
 }
 
 type Session struct {
 	UserID     int
 	SessionKey string
+	// ID is the public id of the session, a jwt access token carries it instead of the key.
+	ID    string
+	Roles []string
+	// Verified is nil until a permission check needs it.
+	Verified *bool
+	// Scopes limit a session started with a personal access token, nil means no limit.
+	Scopes []string
+}
+
+// Info describes a session to its owner. ID is a public identifier,
+// the session key itself is never shown.
+type Info struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
 }
 
 type Storage interface {
-	Create(sessionKey string, userID int) error
-	CheckSession(sessionKey string) (int, error)
-	Delete(sessionKey string) error
-	DeleteAll(userID int) error
+	Create(ctx context.Context, sessionKey string, userID int, info *Info) error
+	CheckSession(ctx context.Context, sessionKey string) (int, error)
+	// List returns live sessions of the user, the one with currentKey is marked Current.
+	List(ctx context.Context, userID int, currentKey string) ([]*Info, error)
+	Delete(ctx context.Context, sessionKey string) error
+	// DeleteWithID returns sql.ErrNoRows if the user has no session with the id.
+	DeleteWithID(ctx context.Context, userID int, id string) error
+	DeleteAll(ctx context.Context, userID int) error
+	DeleteExpired(ctx context.Context) error
+}
+
+// Lifetime limits sessions: a session ends Absolute after the login or Idle after
+// the last request. The idle expiry is moved forward at most once per TouchInterval,
+// so an active session is not written to on every request.
+type Lifetime struct {
+	Absolute      time.Duration
+	Idle          time.Duration
+	TouchInterval time.Duration
 }
 
-func NewSessionHandler(storage Storage, list map[string]map[string]struct{}) *SessionHandler {
+// ExpiresAt is the sliding expiry of a session created at createdAt and seen at lastSeen.
+func (l Lifetime) ExpiresAt(createdAt, lastSeen time.Time) time.Time {
+	absolute := createdAt.Add(l.Absolute)
+	idle := lastSeen.Add(l.Idle)
+	if idle.Before(absolute) {
+		return idle
+	}
+	return absolute
+}
+
+type TokenStorage interface {
+	CheckToken(ctx context.Context, tokenHash []byte) (*apitoken.Token, error)
+}
+
+type UserStorage interface {
+	GetRoles(ctx context.Context, userID int) ([]string, error)
+	IsVerified(ctx context.Context, userID int) (bool, error)
+}
+
+func NewSessionHandler(storage Storage, userStorage UserStorage, list map[string]map[string]struct{}) *SessionHandler {
 	return &SessionHandler{
-		Storage:   storage,
-		WhiteList: list,
+		Storage:     storage,
+		UserStorage: userStorage,
+		WhiteList:   list,
 	}
 }
 
@@ -56,15 +120,99 @@ func (sh *SessionHandler) IdFromSessionContext(r *http.Request) (int, error) {
 	return session.UserID, nil
 }
 
-func (sh *SessionHandler) Create(userID int) (string, error) {
+func (sh *SessionHandler) RolesFromSessionContext(r *http.Request) ([]string, error) {
+	session, err := sessionFromContext(r)
+	if err != nil {
+		return nil, err
+	}
+	return session.Roles, nil
+}
+
+func (sh *SessionHandler) ScopesFromSessionContext(r *http.Request) ([]string, error) {
+	session, err := sessionFromContext(r)
+	if err != nil {
+		return nil, err
+	}
+	return session.Scopes, nil
+}
+
+func (sh *SessionHandler) HasPermission(r *http.Request, permission string) bool {
+	session, err := sessionFromContext(r)
+	if err != nil {
+		return false
+	}
+	allowed, err := sh.can(r.Context(), session, permission)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "checking permission error", "error", err)
+		return false
+	}
+	return allowed
+}
+
+// can checks the roles and scopes of the session and the unverified users policy.
+// The verified flag is loaded only for permissions the policy restricts.
+func (sh *SessionHandler) can(ctx context.Context, session *Session, permission string) (bool, error) {
+	if !rbac.Can(session.Roles, permission) || !session.inScope(permission) {
+		return false, nil
+	}
+
+	if _, ok := sh.UnverifiedDenied[permission]; !ok {
+		return true, nil
+	}
+
+	if session.Verified == nil {
+		verified, err := sh.UserStorage.IsVerified(ctx, session.UserID)
+		if err != nil {
+			return false, err
+		}
+		session.Verified = &verified
+	}
+	return *session.Verified, nil
+}
+
+func (s *Session) inScope(permission string) bool {
+	if s.Scopes == nil {
+		return true
+	}
+	for _, scope := range s.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Create starts a session and sends its key in the Authorization header.
+func (sh *SessionHandler) Create(w http.ResponseWriter, r *http.Request, userID int) error {
+	sessionKey, _, err := sh.newSession(r, userID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Authorization", sessionKey)
+	return nil
+}
+
+// newSession returns the key and the public id of the new session.
+func (sh *SessionHandler) newSession(r *http.Request, userID int) (string, string, error) {
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
 
 	sessionKey := uuid.New().String()
-	err := sh.Storage.Create(sessionKey, userID)
+	id := uuid.New().String()
+	err := sh.Storage.Create(r.Context(), sessionKey, userID, &Info{
+		ID:        id,
+		UserAgent: userAgent,
+		IP:        utils.ClientIP(r),
+	})
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return sessionKey, nil
+	return sessionKey, id, nil
 }
 
 func (sh *SessionHandler) Check(r *http.Request) (*Session, error) {
@@ -73,8 +221,12 @@ func (sh *SessionHandler) Check(r *http.Request) (*Session, error) {
 		return nil, getErrNoAuth()
 	}
 
+	if token := strings.TrimPrefix(sessionKeyFromRec, "Bearer "); apitoken.IsToken(token) {
+		return sh.checkToken(r.Context(), token)
+	}
+
 	var userID int
-	userID, err := sh.Storage.CheckSession(sessionKeyFromRec)
+	userID, err := sh.Storage.CheckSession(r.Context(), sessionKeyFromRec)
 	if err != nil {
 		if err.Error() == sql.ErrNoRows.Error() {
 			return nil, getErrNoAuth()
@@ -82,9 +234,42 @@ func (sh *SessionHandler) Check(r *http.Request) (*Session, error) {
 		return nil, err
 	}
 
+	roles, err := sh.UserStorage.GetRoles(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Session{
 		UserID:     userID,
 		SessionKey: sessionKeyFromRec,
+		Roles:      roles,
+	}, nil
+}
+
+// checkToken starts a session limited to the scopes of the personal access token.
+// The roles are loaded as for other sessions, so the token loses what its owner loses.
+func (sh *SessionHandler) checkToken(ctx context.Context, raw string) (*Session, error) {
+	if sh.Tokens == nil {
+		return nil, getErrNoAuth()
+	}
+
+	token, err := sh.Tokens.CheckToken(ctx, apitoken.Hash(raw))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, getErrNoAuth()
+		}
+		return nil, err
+	}
+
+	roles, err := sh.UserStorage.GetRoles(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		UserID: token.UserID,
+		Roles:  roles,
+		Scopes: append([]string{}, token.Scopes...),
 	}, nil
 }
 
@@ -94,7 +279,7 @@ func (sh *SessionHandler) Delete(r *http.Request) error {
 		return err
 	}
 
-	err = sh.Storage.Delete(session.SessionKey)
+	err = sh.Storage.Delete(r.Context(), session.SessionKey)
 	if err != nil {
 		return err
 	}
@@ -108,7 +293,7 @@ func (sh *SessionHandler) DeleteAll(r *http.Request) error {
 		return err
 	}
 
-	err = sh.Storage.DeleteAll(session.UserID)
+	err = sh.Storage.DeleteAll(r.Context(), session.UserID)
 	if err != nil {
 		return err
 	}
@@ -116,20 +301,108 @@ func (sh *SessionHandler) DeleteAll(r *http.Request) error {
 	return nil
 }
 
-func (sh *SessionHandler) AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func (sh *SessionHandler) DeleteAllWithID(ctx context.Context, userID int) error {
+	return sh.Storage.DeleteAll(ctx, userID)
+}
+
+func (sh *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get session error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-		url := r.URL.Path
-		if strings.Count(url, "/") == 3 {
-			url = url[:strings.LastIndex(url, "/")]
+	sessions, err := sh.Storage.List(r.Context(), session.UserID, session.SessionKey)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "list sessions error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if session.SessionKey == "" && session.ID != "" {
+		for _, info := range sessions {
+			info.Current = info.ID == session.ID
 		}
+	}
+
+	response := utils.Response{
+		"sessions":      sessions,
+		"sessionsCount": len(sessions),
+	}
 
+	utils.SendResponse(w, r, response)
+}
+
+func (sh *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	session, err := sessionFromContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get session error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(id); err != nil {
+		utils.SendErrMessage(w, r, "bad session id", http.StatusBadRequest)
+		return
+	}
+
+	err = sh.Storage.DeleteWithID(r.Context(), session.UserID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "bad id, no session", http.StatusNotFound)
+			return
+		}
+		logger.ErrorContext(r.Context(), "delete session with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Sweep deletes expired sessions every interval until the done channel is closed.
+func (sh *SessionHandler) Sweep(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := sh.Storage.DeleteExpired(context.Background())
+			if err != nil {
+				logger.Error("delete expired sessions error", "error", err)
+			}
+		}
+	}
+}
+
+// inWhiteList looks for the path itself and then, for paths like /api/articles/{id},
+// for the path without the last element.
+func (sh *SessionHandler) inWhiteList(url, method string) bool {
+	if methods, ok := sh.WhiteList[url]; ok {
+		if _, ok := methods[method]; ok {
+			return true
+		}
+	}
+
+	if strings.Count(url, "/") == 3 {
+		url = url[:strings.LastIndex(url, "/")]
 		if methods, ok := sh.WhiteList[url]; ok {
-			if _, ok := methods[r.Method]; ok {
-				next.ServeHTTP(w, r)
-				return
+			if _, ok := methods[method]; ok {
+				return true
 			}
 		}
+	}
+	return false
+}
+
+func (sh *SessionHandler) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if sh.inWhiteList(r.URL.Path, r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		session, err := sh.Check(r)
 		if err != nil {
@@ -137,7 +410,8 @@ func (sh *SessionHandler) AuthMiddleware(next http.Handler) http.Handler {
 				utils.SendErrMessage(w, r, "no auth", http.StatusUnauthorized)
 				return
 			}
-			log.Printf("checking session error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+			logger.ErrorContext(r.Context(), "checking session error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
@@ -146,6 +420,41 @@ func (sh *SessionHandler) AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// RequirePermission wraps a route handler so that it is served only for
+// sessions whose roles grant the permission. It must run after AuthMiddleware.
+func (sh *SessionHandler) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := sessionFromContext(r)
+			if err != nil {
+				utils.SendErrMessage(w, r, "no auth", http.StatusUnauthorized)
+				return
+			}
+
+			allowed, err := sh.can(r.Context(), session, permission)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "checking permission error", "error", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if !allowed {
+				switch {
+				case !rbac.Can(session.Roles, permission):
+					utils.SendErrMessage(w, r, "forbidden", http.StatusForbidden)
+				case !session.inScope(permission):
+					utils.SendErrMessage(w, r, "token has no scope "+permission, http.StatusForbidden)
+				default:
+					utils.SendErrMessage(w, r, "email is not verified", http.StatusForbidden)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func getErrNoAuth() error {
 	return fmt.Errorf("no auth")
 }
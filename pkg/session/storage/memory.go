@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/session"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memorySession struct {
+	userID int
+	info   session.Info
+}
+
+// MemoryStorage keeps sessions in the process, they are not shared between
+// instances and are lost on restart.
+type MemoryStorage struct {
+	Lifetime session.Lifetime
+
+	mu       sync.Mutex
+	sessions map[string]*memorySession
+}
+
+func NewMemoryStorage(lifetime session.Lifetime) *MemoryStorage {
+	return &MemoryStorage{
+		Lifetime: lifetime,
+		sessions: make(map[string]*memorySession),
+	}
+}
+
+func (st *MemoryStorage) Create(ctx context.Context, sessionKey string, userID int, info *session.Info) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	s := &memorySession{userID: userID, info: *info}
+	s.info.CreatedAt = now
+	s.info.LastSeenAt = now
+	s.info.ExpiresAt = st.Lifetime.ExpiresAt(now, now)
+	s.info.Current = false
+	st.sessions[sessionKey] = s
+	return nil
+}
+
+// CheckSession returns sql.ErrNoRows for unknown and expired sessions.
+// An expired session is deleted, a live one gets its idle expiry moved
+// if it was last renewed more than Lifetime.TouchInterval ago.
+func (st *MemoryStorage) CheckSession(ctx context.Context, sessionKey string) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	s, ok := st.sessions[sessionKey]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	if !now.Before(s.info.ExpiresAt) {
+		delete(st.sessions, sessionKey)
+		return 0, sql.ErrNoRows
+	}
+
+	if now.Sub(s.info.LastSeenAt) >= st.Lifetime.TouchInterval {
+		s.info.LastSeenAt = now
+		s.info.ExpiresAt = st.Lifetime.ExpiresAt(s.info.CreatedAt, now)
+	}
+	return s.userID, nil
+}
+
+func (st *MemoryStorage) List(ctx context.Context, userID int, currentKey string) ([]*session.Info, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	sessions := []*session.Info{}
+	for key, s := range st.sessions {
+		if s.userID != userID || !now.Before(s.info.ExpiresAt) {
+			continue
+		}
+		info := s.info
+		info.Current = key == currentKey
+		sessions = append(sessions, &info)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (st *MemoryStorage) Delete(ctx context.Context, sessionKey string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.sessions, sessionKey)
+	return nil
+}
+
+func (st *MemoryStorage) DeleteWithID(ctx context.Context, userID int, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, s := range st.sessions {
+		if s.userID == userID && s.info.ID == id {
+			delete(st.sessions, key)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (st *MemoryStorage) DeleteAll(ctx context.Context, userID int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for key, s := range st.sessions {
+		if s.userID == userID {
+			delete(st.sessions, key)
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) DeleteExpired(ctx context.Context) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range st.sessions {
+		if !now.Before(s.info.ExpiresAt) {
+			delete(st.sessions, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/logging"
+	"rwa/pkg/session"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var logger = logging.For("session/storage")
+
+const cacheChannel = "session_cache"
+
+// Notifier carries session cache invalidations between instances with Postgres LISTEN/NOTIFY.
+type Notifier struct {
+	DB       *sql.DB
+	Listener *pq.Listener
+}
+
+// NewNotifier opens a dedicated listening connection, LISTEN does not work through the sql.DB pool.
+func NewNotifier(db *sql.DB, dsn string) (*Notifier, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("session cache listener error", "error", err)
+		}
+	})
+
+	err := listener.Listen(cacheChannel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &Notifier{
+		DB:       db,
+		Listener: listener,
+	}, nil
+}
+
+func (n *Notifier) Publish(ctx context.Context, event string) error {
+	_, err := n.DB.ExecContext(ctx, "SELECT pg_notify($1, $2)", cacheChannel, event)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Listen applies invalidations to the cache until the done channel is closed.
+// Notifications sent while the connection was lost are gone, so the whole cache is
+// flushed after a reconnect.
+func (n *Notifier) Listen(cache *session.Cache, done <-chan struct{}) {
+	defer n.Listener.Close()
+	for {
+		select {
+		case <-done:
+			return
+		case notification := <-n.Listener.Notify:
+			if notification == nil {
+				cache.Flush()
+				continue
+			}
+			cache.Invalidate(notification.Extra)
+		case <-time.After(90 * time.Second):
+			// a quiet connection may be dead without anybody noticing
+			go n.Listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/session"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStorage keeps sessions in a Redis compatible store. Every session is a hash
+// "<prefix>session:<key>" that expires by itself, and "<prefix>user_sessions:<user id>"
+// is the set of session keys of the user. Keys of expired sessions stay in the set
+// until List, DeleteWithID, DeleteAll or DeleteExpired meets them.
+type RedisStorage struct {
+	Client   redis.UniversalClient
+	Lifetime session.Lifetime
+	Prefix   string
+}
+
+func NewRedisStorage(client redis.UniversalClient, lifetime session.Lifetime, prefix string) *RedisStorage {
+	return &RedisStorage{
+		Client:   client,
+		Lifetime: lifetime,
+		Prefix:   prefix,
+	}
+}
+
+func (st *RedisStorage) sessionKey(sessionKey string) string {
+	return st.Prefix + "session:" + sessionKey
+}
+
+func (st *RedisStorage) userKey(userID int) string {
+	return st.Prefix + "user_sessions:" + strconv.Itoa(userID)
+}
+
+// redisSession keeps times as unix milliseconds, hash fields are plain strings.
+type redisSession struct {
+	UserID     int    `redis:"user_id"`
+	ID         string `redis:"id"`
+	UserAgent  string `redis:"user_agent"`
+	IP         string `redis:"ip"`
+	CreatedAt  int64  `redis:"created_at"`
+	LastSeenAt int64  `redis:"last_seen_at"`
+	ExpiresAt  int64  `redis:"expires_at"`
+}
+
+func (st *RedisStorage) Create(ctx context.Context, sessionKey string, userID int, info *session.Info) error {
+	now := time.Now()
+	expiresAt := st.Lifetime.ExpiresAt(now, now)
+
+	_, err := st.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, st.sessionKey(sessionKey), &redisSession{
+			UserID:     userID,
+			ID:         info.ID,
+			UserAgent:  info.UserAgent,
+			IP:         info.IP,
+			CreatedAt:  now.UnixMilli(),
+			LastSeenAt: now.UnixMilli(),
+			ExpiresAt:  expiresAt.UnixMilli(),
+		})
+		pipe.PExpireAt(ctx, st.sessionKey(sessionKey), expiresAt)
+		pipe.SAdd(ctx, st.userKey(userID), sessionKey)
+		// no session of the user lives longer than the absolute lifetime of the newest one
+		pipe.PExpire(ctx, st.userKey(userID), st.Lifetime.Absolute)
+		return nil
+	})
+	return err
+}
+
+func (st *RedisStorage) get(ctx context.Context, sessionKey string) (*redisSession, error) {
+	s := &redisSession{}
+	cmd := st.Client.HGetAll(ctx, st.sessionKey(sessionKey))
+	values, err := cmd.Result()
+	if err != nil {
+		return nil, err
+	}
+	// a hash without user_id is not a session, whatever wrote it
+	if _, ok := values["user_id"]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	err = cmd.Scan(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// touchScript moves the idle expiry of a session only if the session still has the
+// last_seen_at that was read: a session deleted or touched by another request in
+// between is left as it is, the hash is never created again.
+// KEYS[1] is the session, ARGV are the read last_seen_at, the new one and expires_at.
+var touchScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "last_seen_at") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "last_seen_at", ARGV[2], "expires_at", ARGV[3])
+redis.call("PEXPIREAT", KEYS[1], ARGV[3])
+return 1
+`)
+
+// CheckSession returns sql.ErrNoRows for unknown and expired sessions.
+// A live session gets its idle expiry moved if it was last renewed
+// more than Lifetime.TouchInterval ago.
+func (st *RedisStorage) CheckSession(ctx context.Context, sessionKey string) (int, error) {
+
+	s, err := st.get(ctx, sessionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if !now.Before(time.UnixMilli(s.ExpiresAt)) {
+		return 0, sql.ErrNoRows
+	}
+
+	if now.Sub(time.UnixMilli(s.LastSeenAt)) >= st.Lifetime.TouchInterval {
+		expiresAt := st.Lifetime.ExpiresAt(time.UnixMilli(s.CreatedAt), now)
+		err = touchScript.Run(ctx, st.Client, []string{st.sessionKey(sessionKey)},
+			s.LastSeenAt, now.UnixMilli(), expiresAt.UnixMilli(),
+		).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return s.UserID, nil
+}
+
+func (st *RedisStorage) Delete(ctx context.Context, sessionKey string) error {
+
+	userID, err := st.Client.HGet(ctx, st.sessionKey(sessionKey), "user_id").Int()
+	if err != nil {
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	}
+
+	_, err = st.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, st.sessionKey(sessionKey))
+		pipe.SRem(ctx, st.userKey(userID), sessionKey)
+		return nil
+	})
+	return err
+}
+
+// sessions returns the live sessions of the user by their keys and
+// drops the keys of expired sessions from the user set.
+func (st *RedisStorage) sessions(ctx context.Context, userID int) (map[string]*redisSession, error) {
+	keys, err := st.Client.SMembers(ctx, st.userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	_, err = st.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, st.sessionKey(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make(map[string]*redisSession)
+	stale := []interface{}{}
+	for i, cmd := range cmds {
+		if _, ok := cmd.Val()["user_id"]; !ok {
+			stale = append(stale, keys[i])
+			continue
+		}
+		s := &redisSession{}
+		err = cmd.Scan(s)
+		if err != nil {
+			return nil, err
+		}
+		sessions[keys[i]] = s
+	}
+
+	if len(stale) > 0 {
+		err = st.Client.SRem(ctx, st.userKey(userID), stale...).Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+func (st *RedisStorage) List(ctx context.Context, userID int, currentKey string) ([]*session.Info, error) {
+
+	sessions, err := st.sessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := []*session.Info{}
+	for key, s := range sessions {
+		if !now.Before(time.UnixMilli(s.ExpiresAt)) {
+			continue
+		}
+		result = append(result, &session.Info{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  time.UnixMilli(s.CreatedAt),
+			LastSeenAt: time.UnixMilli(s.LastSeenAt),
+			ExpiresAt:  time.UnixMilli(s.ExpiresAt),
+			Current:    key == currentKey,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeenAt.After(result[j].LastSeenAt)
+	})
+	return result, nil
+}
+
+func (st *RedisStorage) DeleteWithID(ctx context.Context, userID int, id string) error {
+
+	sessions, err := st.sessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for key, s := range sessions {
+		if s.ID == id {
+			_, err = st.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, st.sessionKey(key))
+				pipe.SRem(ctx, st.userKey(userID), key)
+				return nil
+			})
+			return err
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (st *RedisStorage) DeleteAll(ctx context.Context, userID int) error {
+
+	keys, err := st.Client.SMembers(ctx, st.userKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	redisKeys := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		redisKeys = append(redisKeys, st.sessionKey(key))
+	}
+	redisKeys = append(redisKeys, st.userKey(userID))
+
+	return st.Client.Del(ctx, redisKeys...).Err()
+}
+
+// DeleteExpired drops the keys of expired sessions from the user sets,
+// the sessions themselves are removed by Redis.
+func (st *RedisStorage) DeleteExpired(ctx context.Context) error {
+
+	iter := st.Client.Scan(ctx, 0, st.Prefix+"user_sessions:*", 100).Iterator()
+	for iter.Next(ctx) {
+		userID, err := strconv.Atoi(strings.TrimPrefix(iter.Val(), st.Prefix+"user_sessions:"))
+		if err != nil {
+			continue
+		}
+		_, err = st.sessions(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/session"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testLifetime = session.Lifetime{
+	Absolute:      24 * time.Hour,
+	Idle:          time.Hour,
+	TouchInterval: time.Minute,
+}
+
+func newTestRedisStorage(t *testing.T) (*miniredis.Miniredis, *RedisStorage) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return m, NewRedisStorage(client, testLifetime, "test:")
+}
+
+func createSession(t *testing.T, st *RedisStorage, key string, userID int, id string) {
+	t.Helper()
+	err := st.Create(context.Background(), key, userID, &session.Info{ID: id, UserAgent: "test", IP: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Create(%q): %v", key, err)
+	}
+}
+
+func checkGone(t *testing.T, st *RedisStorage, key string) {
+	t.Helper()
+	_, err := st.CheckSession(context.Background(), key)
+	if err != sql.ErrNoRows {
+		t.Fatalf("CheckSession(%q) error = %v, want sql.ErrNoRows", key, err)
+	}
+}
+
+func TestRedisCreateAndCheckSession(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+
+	userID, err := st.CheckSession(context.Background(), "k1")
+	if err != nil {
+		t.Fatalf("CheckSession: %v", err)
+	}
+	if userID != 7 {
+		t.Errorf("user id = %d, want 7", userID)
+	}
+
+	if ttl := m.TTL("test:session:k1"); ttl <= 0 || ttl > testLifetime.Idle {
+		t.Errorf("session ttl = %v, want up to %v", ttl, testLifetime.Idle)
+	}
+	if ok, _ := m.SIsMember("test:user_sessions:7", "k1"); !ok {
+		t.Error("session key is not in the user set")
+	}
+	if ttl := m.TTL("test:user_sessions:7"); ttl != testLifetime.Absolute {
+		t.Errorf("user set ttl = %v, want %v", ttl, testLifetime.Absolute)
+	}
+
+	checkGone(t, st, "unknown")
+}
+
+func TestRedisSessionExpires(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+
+	m.FastForward(testLifetime.Idle + time.Second)
+
+	checkGone(t, st, "k1")
+	if m.Exists("test:session:k1") {
+		t.Error("expired session hash still exists")
+	}
+}
+
+func TestRedisCheckSessionTouches(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+
+	// the session was last seen longer than the touch interval ago
+	seen := time.Now().Add(-2 * testLifetime.TouchInterval).UnixMilli()
+	m.HSet("test:session:k1", "last_seen_at", strconv.FormatInt(seen, 10))
+	m.SetTTL("test:session:k1", time.Minute)
+
+	_, err := st.CheckSession(context.Background(), "k1")
+	if err != nil {
+		t.Fatalf("CheckSession: %v", err)
+	}
+
+	if m.HGet("test:session:k1", "last_seen_at") == strconv.FormatInt(seen, 10) {
+		t.Error("last_seen_at is not moved")
+	}
+	if ttl := m.TTL("test:session:k1"); ttl <= time.Minute {
+		t.Errorf("session ttl = %v, want it extended to the idle lifetime", ttl)
+	}
+}
+
+func TestRedisTouchDoesNotCreateDeletedSession(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+
+	// the session is deleted between the read and the touch of CheckSession
+	now := time.Now().UnixMilli()
+	err := touchScript.Run(context.Background(), st.Client, []string{"test:session:k1"}, now-1, now, now+1000).Err()
+	if err != nil {
+		t.Fatalf("touch: %v", err)
+	}
+	if m.Exists("test:session:k1") {
+		t.Error("touch created the deleted session")
+	}
+}
+
+func TestRedisSessionWithoutUserID(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+	m.HSet("test:session:k1", "last_seen_at", "1", "expires_at", strconv.FormatInt(expiresAt, 10))
+
+	checkGone(t, st, "k1")
+}
+
+func TestRedisDelete(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+	createSession(t, st, "k2", 7, "s2")
+
+	err := st.Delete(context.Background(), "k1")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	checkGone(t, st, "k1")
+	if ok, _ := m.SIsMember("test:user_sessions:7", "k1"); ok {
+		t.Error("deleted session key is still in the user set")
+	}
+	if _, err := st.CheckSession(context.Background(), "k2"); err != nil {
+		t.Errorf("other session: CheckSession: %v", err)
+	}
+
+	err = st.Delete(context.Background(), "unknown")
+	if err != nil {
+		t.Errorf("Delete of unknown session: %v, want nil", err)
+	}
+}
+
+func TestRedisDeleteAll(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+	createSession(t, st, "k2", 7, "s2")
+	createSession(t, st, "k3", 8, "s3")
+
+	err := st.DeleteAll(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	checkGone(t, st, "k1")
+	checkGone(t, st, "k2")
+	if m.Exists("test:user_sessions:7") {
+		t.Error("user set still exists")
+	}
+	if _, err := st.CheckSession(context.Background(), "k3"); err != nil {
+		t.Errorf("session of another user: CheckSession: %v", err)
+	}
+}
+
+func TestRedisDeleteWithID(t *testing.T) {
+	_, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+	createSession(t, st, "k2", 7, "s2")
+	createSession(t, st, "k3", 8, "s3")
+
+	err := st.DeleteWithID(context.Background(), 7, "s1")
+	if err != nil {
+		t.Fatalf("DeleteWithID: %v", err)
+	}
+	checkGone(t, st, "k1")
+	if _, err := st.CheckSession(context.Background(), "k2"); err != nil {
+		t.Errorf("other session: CheckSession: %v", err)
+	}
+
+	// the id of a session of another user
+	err = st.DeleteWithID(context.Background(), 7, "s3")
+	if err != sql.ErrNoRows {
+		t.Errorf("DeleteWithID of foreign session error = %v, want sql.ErrNoRows", err)
+	}
+	if _, err := st.CheckSession(context.Background(), "k3"); err != nil {
+		t.Errorf("session of another user: CheckSession: %v", err)
+	}
+}
+
+func TestRedisList(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+	createSession(t, st, "k2", 7, "s2")
+	createSession(t, st, "k3", 8, "s3")
+
+	// s1 is seen later than s2
+	seen := time.Now().Add(time.Second).UnixMilli()
+	m.HSet("test:session:k1", "last_seen_at", strconv.FormatInt(seen, 10))
+
+	list, err := st.List(context.Background(), 7, "k2")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("List returned %d sessions, want 2", len(list))
+	}
+	if list[0].ID != "s1" || list[1].ID != "s2" {
+		t.Errorf("List order = %s, %s, want s1, s2", list[0].ID, list[1].ID)
+	}
+	if list[0].Current || !list[1].Current {
+		t.Error("only s2 must be current")
+	}
+	if list[1].UserAgent != "test" || list[1].IP != "127.0.0.1" {
+		t.Errorf("session info = %+v", list[1])
+	}
+}
+
+func TestRedisDeleteExpired(t *testing.T) {
+	m, st := newTestRedisStorage(t)
+	createSession(t, st, "k1", 7, "s1")
+
+	m.FastForward(testLifetime.Idle + time.Second)
+	createSession(t, st, "k2", 7, "s2")
+
+	err := st.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	members, err := m.Members("test:user_sessions:7")
+	if err != nil {
+		t.Fatalf("user set: %v", err)
+	}
+	if len(members) != 1 || members[0] != "k2" {
+		t.Errorf("user set = %v, want [k2]", members)
+	}
+}
@@ -1,30 +1,45 @@
 package storage
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/session"
+	"time"
+)
 
 type Storage struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Lifetime session.Lifetime
 }
 
-func NewStorage(db *sql.DB) *Storage {
+func NewStorage(db *sql.DB, lifetime session.Lifetime) *Storage {
 	return &Storage{
-		DB: db,
+		DB:       db,
+		Lifetime: lifetime,
 	}
 }
 
-func (st *Storage) Create(sessionKey string, userID int) error {
+func (st *Storage) Create(ctx context.Context, sessionKey string, userID int, info *session.Info) error {
 
-	_, err := st.DB.Exec("INSERT INTO sessions(session_key, user_id) VALUES($1,$2)", sessionKey, userID)
+	now := time.Now()
+	_, err := st.DB.ExecContext(ctx, "INSERT INTO sessions(id, session_key, user_id, user_agent, ip, created_at, last_seen_at, expires_at) VALUES($1,$2,$3,$4,$5,$6,$6,$7)",
+		info.ID, sessionKey, userID, info.UserAgent, info.IP, now, st.Lifetime.ExpiresAt(now, now),
+	)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (st *Storage) CheckSession(sessionKey string) (int, error) {
+// CheckSession returns sql.ErrNoRows for unknown and expired sessions.
+// An expired session is deleted, a live one gets its idle expiry moved
+// if it was last renewed more than Lifetime.TouchInterval ago.
+func (st *Storage) CheckSession(ctx context.Context, sessionKey string) (int, error) {
 
 	var userID int
-	err := st.DB.QueryRow("SELECT user_id FROM sessions WHERE session_key = $1", sessionKey).Scan(&userID)
+	var createdAt, lastSeenAt, expiresAt time.Time
+	err := st.DB.QueryRowContext(ctx, "SELECT user_id, created_at, last_seen_at, expires_at FROM sessions WHERE session_key = $1", sessionKey).
+		Scan(&userID, &createdAt, &lastSeenAt, &expiresAt)
 	if err != nil {
 		return 0, err
 	}
@@ -33,12 +48,87 @@ func (st *Storage) CheckSession(sessionKey string) (int, error) {
 		return 0, nil //TODO подумать что вернуть, если id=0 ?sql.NoRows()
 	}
 
+	now := time.Now()
+	if !now.Before(expiresAt) {
+		err = st.Delete(ctx, sessionKey)
+		if err != nil {
+			return 0, err
+		}
+		return 0, sql.ErrNoRows
+	}
+
+	if now.Sub(lastSeenAt) >= st.Lifetime.TouchInterval {
+		_, err = st.DB.ExecContext(ctx, "UPDATE sessions SET last_seen_at = $1, expires_at = $2 WHERE session_key = $3",
+			now, st.Lifetime.ExpiresAt(createdAt, now), sessionKey,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return userID, nil
 }
 
-func (st *Storage) Delete(sessionKey string) error {
+func (st *Storage) Delete(ctx context.Context, sessionKey string) error {
+
+	_, err := st.DB.ExecContext(ctx, "DELETE FROM sessions WHERE session_key = $1", sessionKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (st *Storage) List(ctx context.Context, userID int, currentKey string) ([]*session.Info, error) {
+
+	rows, err := st.DB.QueryContext(ctx, `SELECT id, user_agent, ip, created_at, last_seen_at, expires_at, session_key = $1
+	FROM sessions
+	WHERE user_id = $2 AND expires_at > $3
+	ORDER BY last_seen_at DESC`,
+		currentKey, userID, time.Now(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*session.Info{}
+	for rows.Next() {
+		info := &session.Info{}
+		var userAgentSQL, ipSQL sql.NullString
+		err = rows.Scan(&info.ID, &userAgentSQL, &ipSQL, &info.CreatedAt, &info.LastSeenAt, &info.ExpiresAt, &info.Current)
+		if err != nil {
+			return nil, err
+		}
+		info.UserAgent = userAgentSQL.String
+		info.IP = ipSQL.String
+		sessions = append(sessions, info)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (st *Storage) DeleteWithID(ctx context.Context, userID int, id string) error {
+
+	result, err := st.DB.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (st *Storage) DeleteAll(ctx context.Context, userID int) error {
 
-	_, err := st.DB.Exec("DELETE FROM sessions WHERE session_key = $1", sessionKey)
+	_, err := st.DB.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID)
 	if err != nil {
 		return err
 	}
@@ -46,9 +136,9 @@ func (st *Storage) Delete(sessionKey string) error {
 	return nil
 }
 
-func (st *Storage) DeleteAll(userID int) error {
+func (st *Storage) DeleteExpired(ctx context.Context) error {
 
-	_, err := st.DB.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
+	_, err := st.DB.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= $1", time.Now())
 	if err != nil {
 		return err
 	}
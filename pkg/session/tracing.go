@@ -0,0 +1,66 @@
+package session
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) Create(ctx context.Context, sessionKey string, userID int, info *Info) error {
+	ctx, span := tracing.Start(ctx, "session.Storage.Create")
+	err := s.Storage.Create(ctx, sessionKey, userID, info)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) CheckSession(ctx context.Context, sessionKey string) (int, error) {
+	ctx, span := tracing.Start(ctx, "session.Storage.CheckSession")
+	result, err := s.Storage.CheckSession(ctx, sessionKey)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) List(ctx context.Context, userID int, currentKey string) ([]*Info, error) {
+	ctx, span := tracing.Start(ctx, "session.Storage.List")
+	result, err := s.Storage.List(ctx, userID, currentKey)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) Delete(ctx context.Context, sessionKey string) error {
+	ctx, span := tracing.Start(ctx, "session.Storage.Delete")
+	err := s.Storage.Delete(ctx, sessionKey)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) DeleteWithID(ctx context.Context, userID int, id string) error {
+	ctx, span := tracing.Start(ctx, "session.Storage.DeleteWithID")
+	err := s.Storage.DeleteWithID(ctx, userID, id)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) DeleteAll(ctx context.Context, userID int) error {
+	ctx, span := tracing.Start(ctx, "session.Storage.DeleteAll")
+	err := s.Storage.DeleteAll(ctx, userID)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) DeleteExpired(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "session.Storage.DeleteExpired")
+	err := s.Storage.DeleteExpired(ctx)
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,70 @@
+// Package sqlite opens the embedded SQLite database used instead of Postgres
+// by small deployments.
+//
+// Times are written as text in the time zone of the server and compared as text,
+// so the server must keep its time zone (UTC is the safe choice) for the life of
+// the database file.
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// Open opens the database file, creating it if needed. The tables are created
+// by the migrations in migration/sqlite.
+func Open(path string) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Set("_time_format", "sqlite")
+	// transactions take the write lock at once, a deferred one may fail
+	// with "database is locked" when it upgrades from reading to writing
+	params.Set("_txlock", "immediate")
+
+	return sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+}
+
+// StringArray stores a string slice as a JSON array, SQLite has no array type.
+// A nil slice is stored as NULL and an empty one as "[]".
+type StringArray []string
+
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal([]string(a))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (a *StringArray) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(a))
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(a))
+	default:
+		return fmt.Errorf("cannot scan %T into StringArray", src)
+	}
+}
@@ -0,0 +1,108 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 vectors of RFC 6238 Appendix B,
+// the codes are the last 6 of the 8 digits of the RFC.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		step int64
+		code string
+	}{
+		{59, 0x1, "287082"},
+		{1111111109, 0x23523EC, "081804"},
+		{1111111111, 0x23523ED, "050471"},
+		{1234567890, 0x273EF07, "005924"},
+		{2000000000, 0x3F940AA, "279037"},
+		{20000000000, 0x27BC86AA, "353130"},
+	}
+
+	for _, tt := range tests {
+		tm := time.Unix(tt.unix, 0)
+		if step := Step(tm); step != tt.step {
+			t.Errorf("Step(%d) = %#x, want %#x", tt.unix, step, tt.step)
+		}
+		code, err := Code(rfcSecret, tm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+// TestValidateWindow accepts a code Skew periods around its own and returns the
+// step the code was made for, whatever step it is validated in.
+func TestValidateWindow(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := Step(issued)
+	// the first second of the period the code belongs to
+	start := time.Unix(step*int64(Period/time.Second), 0)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"same period", issued, true},
+		{"first second", start, true},
+		{"last second", start.Add(Period - time.Second), true},
+		{"previous period", start.Add(-Period), true},
+		{"next period", start.Add(Period), true},
+		{"last second of the window", start.Add((Skew+1)*Period - time.Second), true},
+		{"first second of the window", start.Add(-Skew * Period), true},
+		{"two periods before", start.Add(-(Skew + 1) * Period), false},
+		{"two periods after", start.Add((Skew + 1) * Period), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok, err := Validate(rfcSecret, code, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && matched != step {
+				t.Fatalf("matched step %#x, want %#x", matched, step)
+			}
+		})
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for _, passcode := range []string{"", "05047", "0504711", "abcdef", "050472"} {
+		_, ok, err := Validate(rfcSecret, passcode, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("code %q is accepted", passcode)
+		}
+	}
+
+	// spaces around the code and a lower case secret are taken
+	_, ok, err := Validate("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", " 050471 ", now)
+	if err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+
+	_, _, err = Validate("not base32!", "050471", now)
+	if err == nil {
+		t.Fatal("bad secret is accepted")
+	}
+}
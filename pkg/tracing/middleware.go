@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler starts the server span of every request, continuing the trace
+// of the traceparent header. It must be the outermost handler, so the logs
+// of the request carry its trace ID.
+func Handler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// RouteMiddleware names the server span after the matched route template,
+// such as "GET /api/articles/{id:[0-9]+}". It is a router middleware, the route
+// is not known before the router matches it.
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + template)
+				span.SetAttributes(semconv.HTTPRoute(template))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware wraps a router middleware in a span that covers the middleware
+// itself: the span ends when the middleware passes the request on or answers it.
+func Middleware(name string, mw mux.MiddlewareFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+			ctx, span := tracer.Start(r.Context(), name,
+				trace.WithAttributes(attribute.String("middleware", name)),
+			)
+			defer span.End()
+
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				span.End()
+				// the handlers after the middleware are children of the server span,
+				// the values the middleware put into the context are kept
+				next.ServeHTTP(w, r.WithContext(trace.ContextWithSpan(r.Context(), parent)))
+			}))
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
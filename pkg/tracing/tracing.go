@@ -0,0 +1,99 @@
+// Package tracing sets up OpenTelemetry tracing. Spans of the HTTP server,
+// the middleware and the storages are sent to the exporter chosen with Setup,
+// the trace context of a request is taken from the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "rwa"
+
+var tracer = otel.Tracer("rwa")
+
+// Setup installs the tracer provider of the exporter: none, stdout or otlp.
+// endpoint is the URL of the OTLP/HTTP collector, empty means the OTEL_EXPORTER_OTLP_*
+// environment variables. sampleRatio is the share of traces started here that are
+// recorded, a request keeps the decision of its caller. The returned function flushes
+// the spans left and must be called before exit.
+func Setup(ctx context.Context, exporter, endpoint string, sampleRatio float64) (func(context.Context) error, error) {
+	// the context is passed on even if this instance records nothing
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		options := []otlptracehttp.Option{}
+		if endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		spanExporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: [%s]", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithProcessPID(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span that is a child of the span in ctx. Without a span in ctx
+// it returns a span that records nothing, so the background loops polling the
+// storages do not start a trace every few seconds.
+func Start(ctx context.Context, name string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer.Start(ctx, name)
+}
+
+// End records err in the span and ends it. sql.ErrNoRows is an answer of
+// the storage, not a failure, so it is not recorded.
+func End(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,136 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2Params are the argon2id parameters of new password hashes, Memory is in KiB.
+type Argon2Params struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+var DefaultArgon2Params = Argon2Params{
+	Time:       1,
+	Memory:     64 * 1024,
+	Threads:    4,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+// legacyArgon2Params were used before hashes got the PHC format:
+// an 8 letter salt followed by the raw key.
+var legacyArgon2Params = Argon2Params{
+	Time:       1,
+	Memory:     64 * 1024,
+	Threads:    4,
+	SaltLength: 8,
+	KeyLength:  32,
+}
+
+const argon2Prefix = "$argon2id$"
+
+// Argon2Hasher makes argon2id hashes in PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+// It also verifies the legacy hashes, they are always reported as outdated.
+type Argon2Hasher struct {
+	Params Argon2Params
+}
+
+func NewArgon2Hasher(params Argon2Params) *Argon2Hasher {
+	return &Argon2Hasher{
+		Params: params,
+	}
+}
+
+func (h *Argon2Hasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Params.Time, h.Params.Memory, h.Params.Threads, h.Params.KeyLength)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, h.Params.Memory, h.Params.Time, h.Params.Threads,
+		b64.EncodeToString(salt), b64.EncodeToString(key),
+	)
+	return []byte(encoded), nil
+}
+
+func (h *Argon2Hasher) Identify(hashed []byte) bool {
+	return strings.HasPrefix(string(hashed), argon2Prefix) || isLegacyArgon2(hashed)
+}
+
+func (h *Argon2Hasher) Verify(password string, hashed []byte) (bool, bool, error) {
+	if isLegacyArgon2(hashed) {
+		return verifyLegacyArgon2(password, hashed), true, nil
+	}
+
+	params, salt, key, err := decodeArgon2(hashed)
+	if err != nil {
+		return false, false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, params != h.Params, nil
+}
+
+func decodeArgon2(hashed []byte) (Argon2Params, []byte, []byte, error) {
+	params := Argon2Params{}
+
+	parts := strings.Split(string(hashed), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errBadHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, errBadHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, errBadHash
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errBadHash
+	}
+
+	key, err := b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, errBadHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func isLegacyArgon2(hashed []byte) bool {
+	return len(hashed) == int(legacyArgon2Params.SaltLength+legacyArgon2Params.KeyLength) &&
+		!strings.HasPrefix(string(hashed), "$")
+}
+
+func verifyLegacyArgon2(password string, hashed []byte) bool {
+	params := legacyArgon2Params
+	salt, key := hashed[:params.SaltLength], hashed[params.SaltLength:]
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptHasher makes and verifies bcrypt hashes ($2a$, $2b$ and $2y$),
+// for example the ones imported from other systems.
+// bcrypt uses only the first 72 bytes of a password, longer ones are refused by Hash.
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		Cost: cost,
+	}
+}
+
+func (h *BcryptHasher) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+}
+
+func (h *BcryptHasher) Identify(hashed []byte) bool {
+	s := string(hashed)
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+func (h *BcryptHasher) Verify(password string, hashed []byte) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword(hashed, []byte(password))
+	if err != nil {
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost(hashed)
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost != h.Cost, nil
+}
@@ -0,0 +1,9 @@
+package user
+
+// The tests of the storages are in package user_test, the storages import this package.
+
+var (
+	LinkIdentity         = (*UserHandler).linkIdentity
+	ErrUnverifiedAccount = errUnverifiedAccount
+	CheckSecondFactor    = (*UserHandler).checkSecondFactor
+)
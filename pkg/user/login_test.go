@@ -0,0 +1,82 @@
+package user_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rwa/pkg/user"
+	"rwa/pkg/user/storage"
+	"strings"
+	"testing"
+)
+
+// testSessions starts no session, Login only needs Create.
+type testSessions struct {
+	user.SessionManager
+}
+
+func (testSessions) Create(w http.ResponseWriter, r *http.Request, userID int) error {
+	w.Header().Set("Authorization", "session")
+	return nil
+}
+
+var testArgon2Params = user.Argon2Params{
+	Time:       1,
+	Memory:     64,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+func login(t *testing.T, uh *user.UserHandler, email, password string) {
+	t.Helper()
+	body := `{"user":{"email":"` + email + `","password":"` + password + `"}}`
+	w := httptest.NewRecorder()
+	uh.Login(w, httptest.NewRequest(http.MethodPost, "/api/users/login", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login status %d: %s", w.Code, w.Body)
+	}
+}
+
+// TestLoginRehash replaces the hash made with another cost on a successful login.
+func TestLoginRehash(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	uh := user.NewUserHandler(st, testSessions{})
+
+	oldParams := testArgon2Params
+	oldParams.Time = 2
+	uh.Passwords = user.NewPasswords(user.NewArgon2Hasher(oldParams))
+	u := newTestUser(t, st, "rehash@example.com", true)
+	hashed, err := uh.Passwords.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.Update(ctx, &user.User{ID: u.ID, PasswordHashed: hashed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same hash is kept while the cost is the same
+	login(t, uh, u.Email, "correct horse")
+	kept, err := st.GetPasswordHasherWithID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kept) != string(hashed) {
+		t.Fatal("hash is replaced without a cost change")
+	}
+
+	uh.Passwords = user.NewPasswords(user.NewArgon2Hasher(testArgon2Params))
+	login(t, uh, u.Email, "correct horse")
+	rehashed, err := st.GetPasswordHasherWithID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(rehashed), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %s is not made with the new cost", rehashed)
+	}
+
+	// the new hash still takes the password
+	login(t, uh, u.Email, "correct horse")
+}
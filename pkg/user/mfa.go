@@ -0,0 +1,422 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rwa/pkg/metrics"
+	"rwa/pkg/totp"
+	"rwa/pkg/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodesCount = 10
+	// mfaMaxAttempts limits the codes tried with one mfa token,
+	// after that the password has to be entered again.
+	mfaMaxAttempts = 5
+)
+
+var errBadMFAToken = errors.New("bad or expired mfa token")
+
+// MFA is the TOTP enrollment of a user. The secret is saved when the
+// enrollment starts and is used for logins only after it is Enabled.
+type MFA struct {
+	Secret  string
+	Enabled bool
+}
+
+type mfaRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+func unmarshalMFARequest(w http.ResponseWriter, r *http.Request, body []byte) *mfaRequest {
+	dataFromBody := make(map[string]*mfaRequest)
+	err := json.Unmarshal(body, &dataFromBody)
+	if err != nil {
+		logger.WarnContext(r.Context(), "unmarshal body json error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+
+	req, ok := dataFromBody["user"]
+	if !ok || req == nil {
+		utils.SendErrMessage(w, r, "no user data", http.StatusBadRequest)
+		return nil
+	}
+
+	return req
+}
+
+// startSession signs the user in, or, if the user enabled two-factor authentication,
+// answers with an mfa token to be sent to /api/users/login/mfa together with a code.
+func (uh *UserHandler) startSession(w http.ResponseWriter, r *http.Request, user *User) {
+
+	mfa, err := uh.Storage.GetMFA(r.Context(), user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorContext(r.Context(), "get mfa error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err == nil && mfa.Enabled {
+		token, err := randomToken()
+		if err != nil {
+			logger.ErrorContext(r.Context(), "generate mfa token error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		expiresAt := time.Now().Add(uh.MFAChallengeTTL)
+		err = uh.Storage.AddMFAChallenge(r.Context(), hashToken(token), user.ID, expiresAt)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "add mfa challenge error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response := utils.Response{
+			"mfaRequired":       true,
+			"mfaToken":          token,
+			"mfaTokenExpiresAt": expiresAt,
+		}
+
+		utils.SendResponse(w, r, response)
+		return
+	}
+
+	err = uh.SessionManager.Create(w, r, user.ID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "create session key error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	metrics.Logins.WithLabelValues("success").Inc()
+
+	response := utils.Response{
+		"user": user,
+	}
+
+	utils.SendResponse(w, r, response)
+}
+
+// LoginMFA is the second step of the login: it exchanges the mfa token
+// and a TOTP or recovery code for a session.
+func (uh *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	req := unmarshalMFARequest(w, r, body)
+	if req == nil {
+		return
+	}
+
+	if req.MFAToken == "" || req.Code == "" {
+		utils.SendErrMessage(w, r, "mfaToken and code must be not empty", http.StatusBadRequest)
+		return
+	}
+
+	id, err := uh.Storage.CheckMFAChallenge(r.Context(), hashToken(req.MFAToken), mfaMaxAttempts)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, errBadMFAToken.Error(), http.StatusUnauthorized)
+			return
+		}
+		logger.ErrorContext(r.Context(), "check mfa challenge error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user info with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ip := utils.ClientIP(r)
+	if uh.LoginGuard != nil {
+		wait, err := uh.LoginGuard.Check(r.Context(), user.Email, ip)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "check login attempts error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			utils.SendErrMessage(w, r, "too many login attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	ok, err := uh.checkSecondFactor(r.Context(), user.ID, req.Code)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "check mfa code error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		uh.loginFailed(w, r, user.Email, ip, user, "invalid code")
+		return
+	}
+
+	err = uh.Storage.DeleteMFAChallenge(r.Context(), hashToken(req.MFAToken))
+	if err != nil {
+		logger.ErrorContext(r.Context(), "delete mfa challenge error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if uh.LoginGuard != nil {
+		err = uh.LoginGuard.Success(r.Context(), user.Email, ip)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "reset login attempts error", "error", err)
+		}
+	}
+
+	err = uh.SessionManager.Create(w, r, user.ID)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "create session key error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	metrics.Logins.WithLabelValues("success").Inc()
+
+	response := utils.Response{
+		"user": user,
+	}
+
+	utils.SendResponse(w, r, response)
+}
+
+// checkSecondFactor accepts a TOTP code or, if the code does not look like one, a recovery code.
+func (uh *UserHandler) checkSecondFactor(ctx context.Context, userID int, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return uh.checkTOTP(ctx, userID, code)
+	}
+	return uh.Storage.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+}
+
+// checkTOTP validates the code against the enabled secret, a code is accepted only once.
+func (uh *UserHandler) checkTOTP(ctx context.Context, userID int, code string) (bool, error) {
+	mfa, err := uh.Storage.GetMFA(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	step, ok, err := totp.Validate(mfa.Secret, code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+	return uh.Storage.UseMFAStep(ctx, userID, step)
+}
+
+// EnrollMFA starts the enrollment: it saves a new secret and sends it with the
+// otpauth:// URI to be shown as a QR code. The secret is not used until EnableMFA.
+func (uh *UserHandler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+
+	id, err := uh.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	mfa, err := uh.Storage.GetMFA(r.Context(), id)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorContext(r.Context(), "get mfa error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err == nil && mfa.Enabled {
+		utils.SendErrMessage(w, r, "two-factor authentication is already enabled", http.StatusBadRequest)
+		return
+	}
+
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user info with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logger.ErrorContext(r.Context(), "generate totp secret error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = uh.Storage.SetMFASecret(r.Context(), id, secret)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "set mfa secret error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := utils.Response{
+		"mfa": map[string]string{
+			"secret": secret,
+			"uri":    totp.ProvisioningURI(uh.MFAIssuer, user.Email, secret),
+		},
+	}
+
+	utils.SendResponse(w, r, response)
+}
+
+// EnableMFA confirms the enrollment with a code from the app and
+// sends the recovery codes, they are shown only in this response.
+func (uh *UserHandler) EnableMFA(w http.ResponseWriter, r *http.Request) {
+
+	id, err := uh.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	req := unmarshalMFARequest(w, r, body)
+	if req == nil {
+		return
+	}
+
+	mfa, err := uh.Storage.GetMFA(r.Context(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "two-factor authentication enrollment is not started", http.StatusBadRequest)
+			return
+		}
+		logger.ErrorContext(r.Context(), "get mfa error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if mfa.Enabled {
+		utils.SendErrMessage(w, r, "two-factor authentication is already enabled", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := uh.checkTOTP(r.Context(), id, req.Code)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "check mfa code error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		utils.SendErrMessage(w, r, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.ErrorContext(r.Context(), "generate recovery codes error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = uh.Storage.EnableMFA(r.Context(), id, hashes)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "enable mfa error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := utils.Response{
+		"recoveryCodes": codes,
+	}
+
+	utils.SendResponse(w, r, response)
+}
+
+// DisableMFA turns two-factor authentication off, it requires a current TOTP code.
+func (uh *UserHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+
+	id, err := uh.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	req := unmarshalMFARequest(w, r, body)
+	if req == nil {
+		return
+	}
+
+	mfa, err := uh.Storage.GetMFA(r.Context(), id)
+	if err != nil && err != sql.ErrNoRows {
+		logger.ErrorContext(r.Context(), "get mfa error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err == sql.ErrNoRows || !mfa.Enabled {
+		utils.SendErrMessage(w, r, "two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := uh.checkTOTP(r.Context(), id, req.Code)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "check mfa code error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		utils.SendErrMessage(w, r, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	err = uh.Storage.DisableMFA(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "disable mfa error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns codes like "abcde-fghij" and the hashes to store.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([][]byte, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		raw := make([]byte, 10)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(raw))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return strings.ToLower(code)
+}
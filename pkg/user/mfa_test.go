@@ -0,0 +1,61 @@
+package user_test
+
+import (
+	"context"
+	"rwa/pkg/totp"
+	"rwa/pkg/user"
+	"rwa/pkg/user/storage"
+	"testing"
+	"time"
+)
+
+// TestTOTPReplay accepts a code once and refuses the codes of earlier steps after it,
+// though they are still inside the window of totp.Validate.
+func TestTOTPReplay(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	uh := user.NewUserHandler(st, nil)
+	u := newTestUser(t, st, "mfa@example.com", true)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SetMFASecret(ctx, u.ID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.EnableMFA(ctx, u.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	current, err := totp.Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := totp.Code(secret, now.Add(-totp.Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"current code", current, true},
+		{"current code again", current, false},
+		{"code of the previous step", previous, false},
+	}
+	for _, tt := range tests {
+		ok, err := user.CheckSecondFactor(uh, ctx, u.ID, tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.ok {
+			t.Fatalf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+)
+
+// ChangeNotifier is a Storage that calls Changed after the roles or the verified
+// flag of a user change, so the copies cached elsewhere can be dropped.
+type ChangeNotifier struct {
+	Storage
+	Changed func(ctx context.Context, userID int)
+}
+
+func NewChangeNotifier(storage Storage, changed func(ctx context.Context, userID int)) *ChangeNotifier {
+	return &ChangeNotifier{
+		Storage: storage,
+		Changed: changed,
+	}
+}
+
+// Update notifies only a new email, it resets the verified flag.
+func (s *ChangeNotifier) Update(ctx context.Context, user *User) error {
+	err := s.Storage.Update(ctx, user)
+	if err == nil && user.Email != "" {
+		s.Changed(ctx, user.ID)
+	}
+	return err
+}
+
+func (s *ChangeNotifier) SetRoles(ctx context.Context, id int, roles []string) error {
+	err := s.Storage.SetRoles(ctx, id, roles)
+	if err == nil {
+		s.Changed(ctx, id)
+	}
+	return err
+}
+
+func (s *ChangeNotifier) AddRoleWithEmail(ctx context.Context, email, role string) error {
+	err := s.Storage.AddRoleWithEmail(ctx, email, role)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.Storage.GetUserWithEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	s.Changed(ctx, user.ID)
+	return nil
+}
+
+func (s *ChangeNotifier) SetVerified(ctx context.Context, id int, email string) error {
+	err := s.Storage.SetVerified(ctx, id, email)
+	if err == nil {
+		s.Changed(ctx, id)
+	}
+	return err
+}
@@ -0,0 +1,228 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"rwa/pkg/metrics"
+	"rwa/pkg/oauth"
+	"rwa/pkg/rbac"
+	"rwa/pkg/utils"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// oauthStateCookie binds a started login to the browser that started it: the
+// callback takes the state only with the cookie, so a victim cannot be made to
+// finish a login started by somebody else (login CSRF).
+const oauthStateCookie = "oauth_state"
+
+// OAuthLogin redirects the user to the provider from the path.
+func (uh *UserHandler) OAuthLogin(w http.ResponseWriter, r *http.Request) {
+
+	if uh.OAuth == nil {
+		utils.SendErrMessage(w, r, "oauth login is disabled", http.StatusNotFound)
+		return
+	}
+
+	authURL, state, err := uh.OAuth.AuthURL(r.Context(), mux.Vars(r)["provider"])
+	if err != nil {
+		if err == oauth.ErrUnknownProvider {
+			utils.SendErrMessage(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		logger.ErrorContext(r.Context(), "start oauth login error", "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, uh.oauthCookie(stateHash(state), int(uh.OAuth.StateTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OAuthCallback signs the user in with the identity from the provider.
+// A new identity is linked to the user with the same email only if the
+// provider verified the email, otherwise a new user is registered.
+func (uh *UserHandler) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+
+	if uh.OAuth == nil {
+		utils.SendErrMessage(w, r, "oauth login is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		utils.SendErrMessage(w, r, "provider error: "+providerErr, http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash(query.Get("state")))) != 1 {
+		utils.SendErrMessage(w, r, oauth.ErrBadState.Error(), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, uh.oauthCookie("", -1))
+
+	identity, err := uh.OAuth.Exchange(r.Context(), mux.Vars(r)["provider"], query.Get("state"), query.Get("code"))
+	if err != nil {
+		switch err {
+		case oauth.ErrUnknownProvider:
+			utils.SendErrMessage(w, r, err.Error(), http.StatusNotFound)
+		case oauth.ErrBadState:
+			utils.SendErrMessage(w, r, err.Error(), http.StatusBadRequest)
+		default:
+			logger.ErrorContext(r.Context(), "finish oauth login error", "error", err)
+			metrics.Logins.WithLabelValues("failure").Inc()
+			utils.SendErrMessage(w, r, "oauth login failed", http.StatusUnauthorized)
+		}
+		return
+	}
+
+	id, err := uh.Storage.GetUserIDWithIdentity(r.Context(), identity.Provider, identity.Subject)
+	if err == sql.ErrNoRows {
+		if identity.Email == "" || !identity.EmailVerified {
+			utils.SendErrMessage(w, r, "provider did not confirm the email", http.StatusBadRequest)
+			return
+		}
+		id, err = uh.linkIdentity(r.Context(), identity)
+	}
+	if err == errUnverifiedAccount {
+		utils.SendErrMessage(w, r, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "find user with oauth identity error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user info with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	uh.startSession(w, r, user)
+}
+
+// oauthCookie is the state cookie of the OAuth paths, a negative maxAge deletes it.
+// Lax lets the browser send it on the redirect back from the provider.
+func (uh *UserHandler) oauthCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/api/users/oauth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(uh.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// stateHash keeps the state itself out of the cookie.
+func stateHash(state string) string {
+	return base64.RawURLEncoding.EncodeToString(hashToken(state))
+}
+
+// errUnverifiedAccount refuses to link an identity to an account whose email was never
+// verified: whoever registered it first would keep its password and sessions.
+var errUnverifiedAccount = errors.New("an account with this email is not verified, sign in with the password and verify the email first")
+
+// linkIdentity links the identity to the user with the verified email, registering one if needed.
+func (uh *UserHandler) linkIdentity(ctx context.Context, identity *oauth.Identity) (int, error) {
+	user, err := uh.Storage.GetUserWithEmail(ctx, identity.Email)
+	if err == sql.ErrNoRows {
+		user, err = uh.registerWithIdentity(ctx, identity)
+		if err == nil {
+			err = uh.Storage.SetVerified(ctx, user.ID, user.Email)
+		}
+	} else if err == nil {
+		if !user.Verified {
+			return 0, errUnverifiedAccount
+		}
+		// the provider verified the email, as a verification link does
+		if uh.adminEmail != "" && user.Email == uh.adminEmail {
+			err = uh.Storage.AddRoleWithEmail(ctx, user.Email, rbac.RoleAdmin)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = uh.Storage.AddIdentity(ctx, user.ID, identity.Provider, identity.Subject)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// registerWithIdentity creates a user with a random password,
+// the user can set a real one through the password reset.
+func (uh *UserHandler) registerWithIdentity(ctx context.Context, identity *oauth.Identity) (*User, error) {
+	username, err := uh.freeUsername(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHashed, err := uh.Passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	newUser := &User{
+		Email:          identity.Email,
+		Username:       username,
+		PasswordHashed: passwordHashed,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Roles:          append([]string{}, rbac.DefaultRoles...),
+	}
+	// the provider verified the email, as a verification link does
+	if identity.EmailVerified && uh.adminEmail != "" && newUser.Email == uh.adminEmail {
+		newUser.Roles = append(newUser.Roles, rbac.RoleAdmin)
+	}
+
+	err = uh.Storage.NewUser(ctx, newUser)
+	if err != nil {
+		return nil, err
+	}
+	metrics.Registrations.Inc()
+	return newUser, nil
+}
+
+func (uh *UserHandler) freeUsername(ctx context.Context, identity *oauth.Identity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	username := base
+	for i := 0; i < 10; i++ {
+		taken, err := uh.Storage.CheckUniqueUsername(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return username, nil
+		}
+		suffix, err := randStringRunes(4)
+		if err != nil {
+			return "", err
+		}
+		username = fmt.Sprintf("%s_%s", base, suffix)
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
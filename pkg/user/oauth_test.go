@@ -0,0 +1,128 @@
+package user_test
+
+import (
+	"context"
+	"database/sql"
+	"rwa/pkg/oauth"
+	"rwa/pkg/rbac"
+	"rwa/pkg/user"
+	"rwa/pkg/user/storage"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestUser(t *testing.T, st user.Storage, email string, verified bool) *user.User {
+	t.Helper()
+	now := time.Now()
+	u := &user.User{
+		Email:          email,
+		Username:       email,
+		PasswordHashed: []byte("hash"),
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Roles:          append([]string{}, rbac.DefaultRoles...),
+	}
+	err := st.NewUser(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified {
+		err = st.SetVerified(context.Background(), u.ID, u.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return u
+}
+
+func testIdentity(email string) *oauth.Identity {
+	return &oauth.Identity{
+		Provider:      "mock",
+		Subject:       "subject-" + email,
+		Email:         email,
+		EmailVerified: true,
+	}
+}
+
+// TestLinkIdentityUnverified covers an account registered with somebody else's email:
+// the owner signing in with a provider must not take over an account whose password
+// and sessions belong to whoever registered it.
+func TestLinkIdentityUnverified(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	uh := user.NewUserHandler(st, nil)
+	u := newTestUser(t, st, "victim@example.com", false)
+
+	_, err := user.LinkIdentity(uh, ctx, testIdentity(u.Email))
+	if err != user.ErrUnverifiedAccount {
+		t.Fatalf("want ErrUnverifiedAccount, got %v", err)
+	}
+
+	_, err = st.GetUserIDWithIdentity(ctx, "mock", "subject-"+u.Email)
+	if err != sql.ErrNoRows {
+		t.Fatalf("identity is linked: %v", err)
+	}
+	verified, err := st.IsVerified(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified {
+		t.Fatal("the account is marked verified")
+	}
+}
+
+func TestLinkIdentityVerified(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	uh := user.NewUserHandler(st, nil)
+	u := newTestUser(t, st, "admin@example.com", true)
+	err := uh.BootstrapAdmin(ctx, u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the role granted at start was taken away, the link grants it again
+	err = st.SetRoles(ctx, u.ID, rbac.DefaultRoles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := user.LinkIdentity(uh, ctx, testIdentity(u.Email))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != u.ID {
+		t.Fatalf("linked to user %d, want %d", id, u.ID)
+	}
+
+	roles, err := st.GetRoles(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(roles, rbac.RoleAdmin) {
+		t.Fatalf("roles %v have no admin", roles)
+	}
+}
+
+func TestLinkIdentityRegisters(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	uh := user.NewUserHandler(st, nil)
+
+	id, err := user.LinkIdentity(uh, ctx, testIdentity("new@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := st.IsVerified(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified {
+		t.Fatal("the registered account is not verified")
+	}
+	linked, err := st.GetUserIDWithIdentity(ctx, "mock", "subject-new@example.com")
+	if err != nil || linked != id {
+		t.Fatalf("identity links to %d, %v, want %d", linked, err, id)
+	}
+}
@@ -1,22 +1,96 @@
 package user
 
 import (
-	"golang.org/x/crypto/argon2"
-	"golang.org/x/exp/rand"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"rwa/pkg/utils"
 )
 
-func hashPassword(originalPassword, salt string) []byte {
-	hashedPass := argon2.IDKey([]byte(originalPassword), []byte(salt), 1, 64*1024, 4, 32)
-	result := make([]byte, len(salt))
-	copy(result, salt)
-	return append(result, hashedPass...)
+var errBadHash = errors.New("bad password hash")
+
+var errUnknownHash = errors.New("unknown password hash format")
+
+var b64 = base64.RawStdEncoding
+
+// MaxPasswordLength is the limit of bcrypt in bytes. It is kept for every hasher,
+// so a password stays valid when PASSWORD_HASHER is switched to bcrypt.
+const MaxPasswordLength = 72
+
+// checkPasswordLength answers 422 to a password longer than MaxPasswordLength.
+func checkPasswordLength(w http.ResponseWriter, r *http.Request, password string) bool {
+	if len(password) > MaxPasswordLength {
+		utils.SendErrMessage(w, r, fmt.Sprintf("password must be at most %d bytes", MaxPasswordLength), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	// Verify reports whether the password matches the hash and whether the hash
+	// was made with other parameters than the hasher uses for new hashes.
+	Verify(password string, hashed []byte) (bool, bool, error)
+	// Identify reports whether the hash is in the format of the hasher.
+	Identify(hashed []byte) bool
+}
+
+// Passwords hashes new passwords with Preferred and verifies a hash with
+// the first hasher that identifies its format, Preferred is tried first.
+type Passwords struct {
+	Preferred PasswordHasher
+	Hashers   []PasswordHasher
+}
+
+func NewPasswords(preferred PasswordHasher, others ...PasswordHasher) *Passwords {
+	return &Passwords{
+		Preferred: preferred,
+		Hashers:   others,
+	}
+}
+
+// DefaultPasswords prefers argon2id and accepts bcrypt and scrypt hashes.
+func DefaultPasswords() *Passwords {
+	return NewPasswords(
+		NewArgon2Hasher(DefaultArgon2Params),
+		NewBcryptHasher(12),
+		NewScryptHasher(DefaultScryptParams),
+	)
+}
+
+func (p *Passwords) Hash(password string) ([]byte, error) {
+	return p.Preferred.Hash(password)
+}
+
+// Verify reports whether the password matches the hash and whether the hash
+// should be replaced with one of the Preferred hasher.
+func (p *Passwords) Verify(password string, hashed []byte) (bool, bool, error) {
+	if p.Preferred.Identify(hashed) {
+		return p.Preferred.Verify(password, hashed)
+	}
+
+	for _, hasher := range p.Hashers {
+		if hasher.Identify(hashed) {
+			match, _, err := hasher.Verify(password, hashed)
+			return match, match, err
+		}
+	}
+	return false, false, errUnknownHash
 }
 
-func randStringRunes(n int) string {
+func randStringRunes(n int) (string, error) {
 	letterRunes := []rune("abcdefghijklmnopqrstuvwxyz")
+	max := big.NewInt(int64(len(letterRunes)))
 	b := make([]rune, n)
 	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = letterRunes[idx.Int64()]
 	}
-	return string(b)
+	return string(b), nil
 }
@@ -0,0 +1,191 @@
+package user
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// testArgon2Params keep the tests fast, the format does not depend on the cost.
+var testArgon2Params = Argon2Params{
+	Time:       1,
+	Memory:     64,
+	Threads:    1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+func TestArgon2PHCRoundTrip(t *testing.T) {
+	h := NewArgon2Hasher(testArgon2Params)
+	hashed, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(hashed), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %s is not in PHC format", hashed)
+	}
+
+	params, salt, key, err := decodeArgon2(hashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != testArgon2Params {
+		t.Fatalf("decoded params %+v, want %+v", params, testArgon2Params)
+	}
+	want := argon2.IDKey([]byte("correct horse"), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	if !bytes.Equal(key, want) {
+		t.Fatal("decoded key does not match the password")
+	}
+
+	match, rehash, err := h.Verify("correct horse", hashed)
+	if err != nil || !match || rehash {
+		t.Fatalf("match %v, rehash %v, error %v", match, rehash, err)
+	}
+	match, _, err = h.Verify("wrong horse", hashed)
+	if err != nil || match {
+		t.Fatalf("wrong password: match %v, error %v", match, err)
+	}
+}
+
+func TestArgon2BadHash(t *testing.T) {
+	h := NewArgon2Hasher(testArgon2Params)
+	for _, hashed := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!$a2V5",
+	} {
+		_, _, err := h.Verify("password", []byte(hashed))
+		if err != errBadHash {
+			t.Errorf("%s: want errBadHash, got %v", hashed, err)
+		}
+	}
+}
+
+// legacyHash makes a hash of the format used before PHC: an 8 letter salt and the raw key.
+func legacyHash(password, salt string) []byte {
+	p := legacyArgon2Params
+	key := argon2.IDKey([]byte(password), []byte(salt), p.Time, p.Memory, p.Threads, p.KeyLength)
+	return append([]byte(salt), key...)
+}
+
+func TestArgon2Legacy(t *testing.T) {
+	h := NewArgon2Hasher(testArgon2Params)
+	hashed := legacyHash("correct horse", "abcdefgh")
+
+	if !isLegacyArgon2(hashed) || !h.Identify(hashed) {
+		t.Fatal("legacy hash is not identified")
+	}
+	match, rehash, err := h.Verify("correct horse", hashed)
+	if err != nil || !match || !rehash {
+		t.Fatalf("match %v, rehash %v, error %v", match, rehash, err)
+	}
+	match, _, err = h.Verify("wrong horse", hashed)
+	if err != nil || match {
+		t.Fatalf("wrong password: match %v, error %v", match, err)
+	}
+
+	// 40 bytes of another format are not taken for a legacy hash
+	other := []byte("$2a$" + strings.Repeat("x", 36))
+	if isLegacyArgon2(other) {
+		t.Fatal("hash starting with $ is taken for a legacy one")
+	}
+}
+
+func TestPasswordsRehash(t *testing.T) {
+	cheaper := testArgon2Params
+	cheaper.Time = 2
+
+	bcryptHashed, err := NewBcryptHasher(4).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scryptHashed, err := NewScryptHasher(ScryptParams{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hashed, err := NewArgon2Hasher(cheaper).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentHashed, err := NewArgon2Hasher(testArgon2Params).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwords := NewPasswords(NewArgon2Hasher(testArgon2Params), NewBcryptHasher(4), NewScryptHasher(DefaultScryptParams))
+	tests := []struct {
+		name   string
+		hashed []byte
+		rehash bool
+	}{
+		{"current argon2id", currentHashed, false},
+		{"argon2id with other cost", argon2Hashed, true},
+		{"legacy argon2id", legacyHash("correct horse", "abcdefgh"), true},
+		{"bcrypt", bcryptHashed, true},
+		{"scrypt", scryptHashed, true},
+	}
+	for _, tt := range tests {
+		match, rehash, err := passwords.Verify("correct horse", tt.hashed)
+		if err != nil || !match || rehash != tt.rehash {
+			t.Errorf("%s: match %v, rehash %v, error %v, want rehash %v", tt.name, match, rehash, err, tt.rehash)
+		}
+	}
+
+	_, _, err = passwords.Verify("correct horse", []byte("$md5$whatever"))
+	if err != errUnknownHash {
+		t.Fatalf("want errUnknownHash, got %v", err)
+	}
+}
+
+func TestBcryptCost(t *testing.T) {
+	hashed, err := NewBcryptHasher(4).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match, rehash, err := NewBcryptHasher(4).Verify("correct horse", hashed)
+	if err != nil || !match || rehash {
+		t.Fatalf("same cost: match %v, rehash %v, error %v", match, rehash, err)
+	}
+	match, rehash, err = NewBcryptHasher(5).Verify("correct horse", hashed)
+	if err != nil || !match || !rehash {
+		t.Fatalf("other cost: match %v, rehash %v, error %v", match, rehash, err)
+	}
+}
+
+func TestBcryptTooLong(t *testing.T) {
+	h := NewBcryptHasher(4)
+	long := strings.Repeat("x", MaxPasswordLength+1)
+
+	_, err := h.Hash(long)
+	if err == nil {
+		t.Fatal("bcrypt hashes a password over 72 bytes")
+	}
+
+	// a login with a longer password is compared as bcrypt does, without an error
+	hashed, err := h.Hash(long[:MaxPasswordLength])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = h.Verify(long, hashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestPasswordTooLong answers 422 before the password gets to the hasher or the storage.
+func TestPasswordTooLong(t *testing.T) {
+	uh := &UserHandler{Passwords: NewPasswords(NewBcryptHasher(4))}
+	body := `{"user":{"token":"token","password":"` + strings.Repeat("x", MaxPasswordLength+1) + `"}}`
+
+	w := httptest.NewRecorder()
+	uh.ResetPassword(w, httptest.NewRequest(http.MethodPost, "/api/users/password/reset", strings.NewReader(body)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422", w.Code)
+	}
+}
@@ -0,0 +1,210 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rwa/pkg/mail"
+	"rwa/pkg/utils"
+	"time"
+)
+
+type passwordReset struct {
+	Email    string `json:"email"`
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func unmarshalPasswordReset(w http.ResponseWriter, r *http.Request, body []byte) *passwordReset {
+	dataFromBody := make(map[string]*passwordReset)
+	err := json.Unmarshal(body, &dataFromBody)
+	if err != nil {
+		logger.WarnContext(r.Context(), "unmarshal body json error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+
+	reset, ok := dataFromBody["user"]
+	if !ok || reset == nil {
+		utils.SendErrMessage(w, r, "no user data", http.StatusBadRequest)
+		return nil
+	}
+
+	return reset
+}
+
+// randomToken returns a random single-use token, only its hashToken is stored.
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// ForgotPassword always answers 202, the token is issued and mailed in the background,
+// so neither the answer nor its timing tell whether the email is registered.
+func (uh *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	reset := unmarshalPasswordReset(w, r, body)
+	if reset == nil {
+		return
+	}
+
+	if reset.Email == "" {
+		utils.SendErrMessage(w, r, "email must be not empty", http.StatusBadRequest)
+		return
+	}
+
+	// the mail is sent after the response, the context keeps only the request values
+	select {
+	case uh.passwordResets <- queuedReset{ctx: context.WithoutCancel(r.Context()), email: reset.Email}:
+	default:
+		logger.WarnContext(r.Context(), "password reset queue is full, the request is dropped")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// passwordResetQueue is how many password resets wait for SendPasswordResets,
+// the requests above it are dropped with the same answer.
+const passwordResetQueue = 100
+
+type queuedReset struct {
+	ctx   context.Context
+	email string
+}
+
+// SendPasswordResets sends the password resets queued by ForgotPassword one by one.
+// When the done channel is closed it sends the queued ones and returns.
+func (uh *UserHandler) SendPasswordResets(done <-chan struct{}) {
+	for {
+		select {
+		case reset := <-uh.passwordResets:
+			uh.sendQueuedPasswordReset(reset)
+		case <-done:
+			for {
+				select {
+				case reset := <-uh.passwordResets:
+					uh.sendQueuedPasswordReset(reset)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (uh *UserHandler) sendQueuedPasswordReset(reset queuedReset) {
+	err := uh.sendPasswordReset(reset.ctx, reset.email)
+	if err != nil {
+		logger.ErrorContext(reset.ctx, "send password reset error", "error", err)
+	}
+}
+
+func (uh *UserHandler) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := uh.Storage.GetUserWithEmail(ctx, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if uh.Mailer == nil {
+		return fmt.Errorf("no mailer to send password reset")
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(uh.PasswordResetTTL)
+	err = uh.Storage.AddPasswordReset(ctx, user.ID, hashToken(token), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return uh.Mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Somebody asked to reset the password of your account.\n"+
+			"To set a new password send POST %s/api/users/password/reset with the token:\n%s\n\n"+
+			"The token can be used once and is valid until %s.\n"+
+			"If it was not you, ignore this message.\n",
+			uh.PublicURL, token, expiresAt.Format(time.RFC1123)),
+	})
+}
+
+func (uh *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	reset := unmarshalPasswordReset(w, r, body)
+	if reset == nil {
+		return
+	}
+
+	if reset.Token == "" || reset.Password == "" {
+		utils.SendErrMessage(w, r, "token and password must be not empty", http.StatusBadRequest)
+		return
+	}
+	if !checkPasswordLength(w, r, reset.Password) {
+		return
+	}
+
+	passwordHashed, err := uh.Passwords.Hash(reset.Password)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "hash password error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uh.Storage.ResetPassword(r.Context(), hashToken(reset.Token), passwordHashed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, errBadToken.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.ErrorContext(r.Context(), "reset password error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = uh.SessionManager.DeleteAllWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "delete all sessions error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if uh.LoginGuard != nil {
+		user, err := uh.Storage.GetUserWithID(r.Context(), id)
+		if err == nil {
+			err = uh.LoginGuard.Unlock(r.Context(), user.Email)
+		}
+		if err != nil {
+			logger.ErrorContext(r.Context(), "reset login attempts error", "error", err)
+		}
+	}
+}
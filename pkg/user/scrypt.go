@@ -0,0 +1,109 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ScryptParams are the scrypt parameters of new password hashes, N is 2^LogN.
+type ScryptParams struct {
+	LogN       uint8
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+var DefaultScryptParams = ScryptParams{
+	LogN:       15,
+	R:          8,
+	P:          1,
+	SaltLength: 16,
+	KeyLength:  32,
+}
+
+const scryptPrefix = "$scrypt$"
+
+// ScryptHasher makes scrypt hashes in PHC string format:
+// $scrypt$ln=15,r=8,p=1$<salt>$<key>
+type ScryptHasher struct {
+	Params ScryptParams
+}
+
+func NewScryptHasher(params ScryptParams) *ScryptHasher {
+	return &ScryptHasher{
+		Params: params,
+	}
+}
+
+func (h *ScryptHasher) Hash(password string) ([]byte, error) {
+	salt := make([]byte, h.Params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.Params.LogN, h.Params.R, h.Params.P, h.Params.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := fmt.Sprintf("%sln=%d,r=%d,p=%d$%s$%s",
+		scryptPrefix, h.Params.LogN, h.Params.R, h.Params.P,
+		b64.EncodeToString(salt), b64.EncodeToString(key),
+	)
+	return []byte(encoded), nil
+}
+
+func (h *ScryptHasher) Identify(hashed []byte) bool {
+	return strings.HasPrefix(string(hashed), scryptPrefix)
+}
+
+func (h *ScryptHasher) Verify(password string, hashed []byte) (bool, bool, error) {
+	params, salt, key, err := decodeScrypt(hashed)
+	if err != nil {
+		return false, false, err
+	}
+
+	otherKey, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, params.KeyLength)
+	if err != nil {
+		return false, false, err
+	}
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, params != h.Params, nil
+}
+
+func decodeScrypt(hashed []byte) (ScryptParams, []byte, []byte, error) {
+	params := ScryptParams{}
+
+	parts := strings.Split(string(hashed), "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return params, nil, nil, errBadHash
+	}
+
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P)
+	if err != nil || params.LogN < 1 || params.LogN > 31 {
+		return params, nil, nil, errBadHash
+	}
+
+	salt, err := b64.DecodeString(parts[3])
+	if err != nil {
+		return params, nil, nil, errBadHash
+	}
+
+	key, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errBadHash
+	}
+
+	params.SaltLength = len(salt)
+	params.KeyLength = len(key)
+	return params, salt, key, nil
+}
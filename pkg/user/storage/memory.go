@@ -0,0 +1,503 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"rwa/pkg/rbac"
+	"rwa/pkg/user"
+	"sync"
+	"time"
+)
+
+var errEmailExists = errors.New("user with this email exists")
+
+var errUsernameExists = errors.New("user with this username exists")
+
+type memoryMFA struct {
+	secret   string
+	enabled  bool
+	lastStep int64
+	// recoveryCodes maps code hashes to whether the code is used
+	recoveryCodes map[string]bool
+}
+
+type memoryReset struct {
+	userID    int
+	expiresAt time.Time
+	used      bool
+}
+
+type memoryChallenge struct {
+	userID    int
+	attempts  int
+	expiresAt time.Time
+}
+
+// MemoryStorage keeps users in the process, everything is lost on restart.
+// OnDelete is called with the id of every deleted user, so other in-memory
+// storages can drop the rows Postgres removes with ON DELETE CASCADE.
+type MemoryStorage struct {
+	OnDelete []func(ctx context.Context, userID int) error
+
+	mu         sync.Mutex
+	lastID     int
+	users      map[int]*user.User
+	resets     map[string]*memoryReset
+	identities map[[2]string]int
+	mfa        map[int]*memoryMFA
+	challenges map[string]*memoryChallenge
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:      make(map[int]*user.User),
+		resets:     make(map[string]*memoryReset),
+		identities: make(map[[2]string]int),
+		mfa:        make(map[int]*memoryMFA),
+		challenges: make(map[string]*memoryChallenge),
+	}
+}
+
+func (st *MemoryStorage) GetErrNoUpdate() error {
+	return errNoUpdate
+}
+
+// copyUser returns the user as it is read from the table: bio and image are never nil.
+func copyUser(u *user.User) *user.User {
+	c := *u
+	c.Bio = new(string)
+	if u.Bio != nil {
+		*c.Bio = *u.Bio
+	}
+	c.Image = new(string)
+	if u.Image != nil {
+		*c.Image = *u.Image
+	}
+	c.PasswordHashed = append([]byte(nil), u.PasswordHashed...)
+	c.Roles = append([]string(nil), u.Roles...)
+	c.Password = ""
+	return &c
+}
+
+func (st *MemoryStorage) findEmail(email string) *user.User {
+	for _, u := range st.users {
+		if u.Email == email {
+			return u
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) findUsername(username string) *user.User {
+	for _, u := range st.users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) NewUser(ctx context.Context, newUser *user.User) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.findEmail(newUser.Email) != nil {
+		return errEmailExists
+	}
+	if st.findUsername(newUser.Username) != nil {
+		return errUsernameExists
+	}
+
+	st.lastID++
+	saved := &user.User{
+		ID:             st.lastID,
+		Email:          newUser.Email,
+		PasswordHashed: append([]byte(nil), newUser.PasswordHashed...),
+		CreatedAt:      newUser.CreatedAt,
+		UpdatedAt:      newUser.UpdatedAt,
+		Username:       newUser.Username,
+		Bio:            newUser.Bio,
+		Image:          newUser.Image,
+		Roles:          append([]string(nil), newUser.Roles...),
+	}
+	if saved.Roles == nil {
+		saved.Roles = append([]string(nil), rbac.DefaultRoles...)
+	}
+	st.users[saved.ID] = copyUser(saved)
+
+	newUser.ID = saved.ID
+	return nil
+}
+
+func (st *MemoryStorage) Update(ctx context.Context, update *user.User) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if update.Email == "" && update.PasswordHashed == nil && update.Username == "" && update.Bio == nil && update.Image == nil {
+		return st.GetErrNoUpdate()
+	}
+	update.UpdatedAt = time.Now()
+
+	saved, ok := st.users[update.ID]
+	if !ok {
+		return nil
+	}
+
+	if update.Email != "" {
+		if other := st.findEmail(update.Email); other != nil && other.ID != saved.ID {
+			return errEmailExists
+		}
+	}
+	if update.Username != "" {
+		if other := st.findUsername(update.Username); other != nil && other.ID != saved.ID {
+			return errUsernameExists
+		}
+	}
+
+	if update.Email != "" {
+		saved.Email = update.Email
+		saved.Verified = false
+	}
+	if update.PasswordHashed != nil {
+		saved.PasswordHashed = append([]byte(nil), update.PasswordHashed...)
+	}
+	if update.Username != "" {
+		saved.Username = update.Username
+	}
+	if update.Bio != nil {
+		*saved.Bio = *update.Bio
+	}
+	if update.Image != nil {
+		*saved.Image = *update.Image
+	}
+	saved.UpdatedAt = update.UpdatedAt
+	return nil
+}
+
+func (st *MemoryStorage) GetUserWithEmail(ctx context.Context, email string) (*user.User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u := st.findEmail(email)
+	if u == nil {
+		return nil, sql.ErrNoRows
+	}
+	return copyUser(u), nil
+}
+
+func (st *MemoryStorage) GetUserWithID(ctx context.Context, id int) (*user.User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return copyUser(u), nil
+}
+
+// Delete removes the user with the password resets, identities and MFA data
+// and then calls OnDelete.
+func (st *MemoryStorage) Delete(ctx context.Context, id int) error {
+	st.mu.Lock()
+	_, ok := st.users[id]
+	delete(st.users, id)
+	for hash, reset := range st.resets {
+		if reset.userID == id {
+			delete(st.resets, hash)
+		}
+	}
+	for identity, userID := range st.identities {
+		if userID == id {
+			delete(st.identities, identity)
+		}
+	}
+	delete(st.mfa, id)
+	for hash, challenge := range st.challenges {
+		if challenge.userID == id {
+			delete(st.challenges, hash)
+		}
+	}
+	st.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	for _, onDelete := range st.OnDelete {
+		err := onDelete(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) GetPasswordHasherWithID(ctx context.Context, id int) ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return append([]byte(nil), u.PasswordHashed...), nil
+}
+
+func (st *MemoryStorage) CheckUniqueEmail(ctx context.Context, email string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.findEmail(email) != nil, nil
+}
+
+func (st *MemoryStorage) CheckUniqueUsername(ctx context.Context, username string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.findUsername(username) != nil, nil
+}
+
+func (st *MemoryStorage) GetRoles(ctx context.Context, id int) ([]string, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return append([]string(nil), u.Roles...), nil
+}
+
+func (st *MemoryStorage) SetRoles(ctx context.Context, id int, roles []string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	u.Roles = append([]string(nil), roles...)
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (st *MemoryStorage) AddRoleWithEmail(ctx context.Context, email, role string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u := st.findEmail(email)
+	if u == nil {
+		return sql.ErrNoRows
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return nil
+		}
+	}
+	u.Roles = append(u.Roles, role)
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+// SetVerified marks the user verified if the email is still the one the token was issued for.
+func (st *MemoryStorage) SetVerified(ctx context.Context, id int, email string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.users[id]
+	if !ok || u.Email != email {
+		return sql.ErrNoRows
+	}
+	u.Verified = true
+	return nil
+}
+
+func (st *MemoryStorage) IsVerified(ctx context.Context, id int) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.users[id]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return u.Verified, nil
+}
+
+func (st *MemoryStorage) AddPasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.resets[string(tokenHash)] = &memoryReset{
+		userID:    userID,
+		expiresAt: expiresAt,
+	}
+	return nil
+}
+
+// ResetPassword sets the password hash of the user the token was issued to and
+// spends the token with every other unused token of the user. It returns the ID
+// of the user and sql.ErrNoRows for unknown, used or expired tokens.
+func (st *MemoryStorage) ResetPassword(ctx context.Context, tokenHash, passwordHashed []byte) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	reset, ok := st.resets[string(tokenHash)]
+	if !ok || reset.used || !time.Now().Before(reset.expiresAt) {
+		return 0, sql.ErrNoRows
+	}
+	u, ok := st.users[reset.userID]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	u.PasswordHashed = append([]byte(nil), passwordHashed...)
+	u.UpdatedAt = time.Now()
+
+	for _, other := range st.resets {
+		if other.userID == reset.userID {
+			other.used = true
+		}
+	}
+	return reset.userID, nil
+}
+
+func (st *MemoryStorage) GetUserIDWithIdentity(ctx context.Context, provider, subject string) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	id, ok := st.identities[[2]string{provider, subject}]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return id, nil
+}
+
+func (st *MemoryStorage) AddIdentity(ctx context.Context, userID int, provider, subject string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.identities[[2]string{provider, subject}]; ok {
+		return errors.New("identity is linked to another user")
+	}
+	st.identities[[2]string{provider, subject}] = userID
+	return nil
+}
+
+func (st *MemoryStorage) GetMFA(ctx context.Context, userID int) (*user.MFA, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	mfa, ok := st.mfa[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &user.MFA{Secret: mfa.secret, Enabled: mfa.enabled}, nil
+}
+
+func (st *MemoryStorage) SetMFASecret(ctx context.Context, userID int, secret string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	mfa, ok := st.mfa[userID]
+	if ok && mfa.enabled {
+		return nil
+	}
+	st.mfa[userID] = &memoryMFA{secret: secret}
+	return nil
+}
+
+func (st *MemoryStorage) EnableMFA(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	mfa, ok := st.mfa[userID]
+	if !ok {
+		return nil
+	}
+	mfa.enabled = true
+	mfa.recoveryCodes = make(map[string]bool)
+	for _, codeHash := range recoveryCodeHashes {
+		mfa.recoveryCodes[string(codeHash)] = false
+	}
+	return nil
+}
+
+func (st *MemoryStorage) DisableMFA(ctx context.Context, userID int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.mfa, userID)
+	for hash, challenge := range st.challenges {
+		if challenge.userID == userID {
+			delete(st.challenges, hash)
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	mfa, ok := st.mfa[userID]
+	if !ok || mfa.lastStep >= step {
+		return false, nil
+	}
+	mfa.lastStep = step
+	return true, nil
+}
+
+func (st *MemoryStorage) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	mfa, ok := st.mfa[userID]
+	if !ok {
+		return false, nil
+	}
+	used, ok := mfa.recoveryCodes[string(codeHash)]
+	if !ok || used {
+		return false, nil
+	}
+	mfa.recoveryCodes[string(codeHash)] = true
+	return true, nil
+}
+
+func (st *MemoryStorage) AddMFAChallenge(ctx context.Context, tokenHash []byte, userID int, expiresAt time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.challenges[string(tokenHash)] = &memoryChallenge{
+		userID:    userID,
+		expiresAt: expiresAt,
+	}
+
+	// challenges live for minutes, expired ones are dropped on the way
+	now := time.Now()
+	for hash, challenge := range st.challenges {
+		if challenge.expiresAt.Before(now) {
+			delete(st.challenges, hash)
+		}
+	}
+	return nil
+}
+
+func (st *MemoryStorage) CheckMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	challenge, ok := st.challenges[string(tokenHash)]
+	if !ok || !time.Now().Before(challenge.expiresAt) || challenge.attempts >= maxAttempts {
+		return 0, sql.ErrNoRows
+	}
+	challenge.attempts++
+	return challenge.userID, nil
+}
+
+func (st *MemoryStorage) DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	delete(st.challenges, string(tokenHash))
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"rwa/pkg/sqlite"
+	"rwa/pkg/user"
+	"time"
+)
+
+// SQLiteStorage keeps users in SQLite. The queries of Storage run on SQLite as they are,
+// only the ones touching the roles array are replaced: roles are a JSON array.
+type SQLiteStorage struct {
+	*Storage
+}
+
+func NewSQLiteStorage(db *sql.DB) *SQLiteStorage {
+	return &SQLiteStorage{
+		Storage: NewStorage(db),
+	}
+}
+
+func (st *SQLiteStorage) NewUser(ctx context.Context, user *user.User) error {
+	var LastInsertId int
+
+	err := st.db.QueryRowContext(ctx, "INSERT INTO users(email,username,password_hashed,bio,image,roles,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id",
+		user.Email, user.Username, user.PasswordHashed, user.Bio, user.Image, sqlite.StringArray(user.Roles), user.CreatedAt, user.UpdatedAt,
+	).Scan(&LastInsertId)
+
+	if err != nil {
+		return err
+	}
+
+	if LastInsertId == 0 {
+		return fmt.Errorf("no last insert id")
+	}
+	user.ID = LastInsertId
+
+	return nil
+}
+
+func (st *SQLiteStorage) getUser(ctx context.Context, where string, arg interface{}) (*user.User, error) {
+	u := &user.User{}
+	var bioSQL, imageSQL sql.NullString
+	var roles sqlite.StringArray
+
+	err := st.db.
+		QueryRowContext(ctx, "SELECT id, email, username, password_hashed, bio, image, roles, verified, created_at, updated_at FROM users WHERE "+where+" = $1", arg).
+		Scan(&u.ID, &u.Email, &u.Username, &u.PasswordHashed, &bioSQL, &imageSQL, &roles, &u.Verified, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Bio = &bioSQL.String
+	u.Image = &imageSQL.String
+	u.Roles = roles
+	return u, nil
+}
+
+func (st *SQLiteStorage) GetUserWithEmail(ctx context.Context, email string) (*user.User, error) {
+	return st.getUser(ctx, "email", email)
+}
+
+func (st *SQLiteStorage) GetUserWithID(ctx context.Context, id int) (*user.User, error) {
+	return st.getUser(ctx, "id", id)
+}
+
+func (st *SQLiteStorage) GetRoles(ctx context.Context, id int) ([]string, error) {
+	var roles sqlite.StringArray
+	err := st.db.QueryRowContext(ctx, "SELECT roles FROM users WHERE id=$1", id).Scan(&roles)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (st *SQLiteStorage) SetRoles(ctx context.Context, id int, roles []string) error {
+	result, err := st.db.ExecContext(ctx, "UPDATE users SET roles = $1, updated_at = $2 WHERE id = $3", sqlite.StringArray(roles), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (st *SQLiteStorage) AddRoleWithEmail(ctx context.Context, email, role string) error {
+	result, err := st.db.ExecContext(ctx,
+		"UPDATE users SET roles = json_insert(roles, '$[#]', $1), updated_at = $2 WHERE email = $3 AND NOT EXISTS (SELECT 1 FROM json_each(roles) WHERE value = $1)",
+		role, time.Now(), email,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var exists bool
+		err = st.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT id FROM users WHERE email=$1)", email).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"rwa/pkg/user"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 var errNoUpdate = errors.New("no data to update")
@@ -24,7 +27,7 @@ func (st *Storage) GetErrNoUpdate() error {
 	return errNoUpdate
 }
 
-func (st *Storage) NewUser(user *user.User) error {
+func (st *Storage) NewUser(ctx context.Context, user *user.User) error {
 	var LastInsertId int
 	var bioSQL, imageSQL sql.NullString
 
@@ -42,8 +45,8 @@ func (st *Storage) NewUser(user *user.User) error {
 		imageSQL.Valid = true
 	}
 
-	err := st.db.QueryRow("INSERT INTO users(email,username,password_hashed,bio,image,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6,$7) RETURNING id",
-		user.Email, user.Username, user.PasswordHashed, bioSQL, imageSQL, user.CreatedAt, user.UpdatedAt,
+	err := st.db.QueryRowContext(ctx, "INSERT INTO users(email,username,password_hashed,bio,image,roles,created_at,updated_at) VALUES($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id",
+		user.Email, user.Username, user.PasswordHashed, bioSQL, imageSQL, pq.Array(user.Roles), user.CreatedAt, user.UpdatedAt,
 	).Scan(&LastInsertId)
 
 	if err != nil {
@@ -53,18 +56,19 @@ func (st *Storage) NewUser(user *user.User) error {
 	if LastInsertId == 0 {
 		return fmt.Errorf("no last insert id")
 	}
+	user.ID = LastInsertId
 
 	return nil
 }
 
-func (st *Storage) Update(user *user.User) error {
+func (st *Storage) Update(ctx context.Context, user *user.User) error {
 
 	query := "UPDATE users SET "
 	placeholderNum := 1
 	args := make([]interface{}, 0)
 
 	if user.Email != "" {
-		query += fmt.Sprintf("email = $%v, ", placeholderNum)
+		query += fmt.Sprintf("email = $%v, verified = false, verified_at = NULL, ", placeholderNum)
 		placeholderNum++
 		args = append(args, user.Email)
 	}
@@ -101,7 +105,7 @@ func (st *Storage) Update(user *user.User) error {
 
 	args = append(args, user.UpdatedAt, user.ID)
 
-	_, err := st.db.Exec(query, args...)
+	_, err := st.db.ExecContext(ctx, query, args...)
 
 	if err != nil {
 		return err
@@ -109,16 +113,18 @@ func (st *Storage) Update(user *user.User) error {
 	return nil
 }
 
-func (st *Storage) GetUserWithEmail(email string) (*user.User, error) {
+func (st *Storage) GetUserWithEmail(ctx context.Context, email string) (*user.User, error) {
 	var id int
 	var username string
 	var createdAt, updatedAt time.Time
 	var passwordHashed []byte
 	var bioSQL, imageSQL sql.NullString
+	var roles []string
+	var verified bool
 
 	err := st.db.
-		QueryRow("SELECT id, username, password_hashed, bio, image, created_at, updated_at FROM users WHERE email=$1", email).
-		Scan(&id, &username, &passwordHashed, &bioSQL, &imageSQL, &createdAt, &updatedAt)
+		QueryRowContext(ctx, "SELECT id, username, password_hashed, bio, image, roles, verified, created_at, updated_at FROM users WHERE email=$1", email).
+		Scan(&id, &username, &passwordHashed, &bioSQL, &imageSQL, pq.Array(&roles), &verified, &createdAt, &updatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -141,19 +147,23 @@ func (st *Storage) GetUserWithEmail(email string) (*user.User, error) {
 		Username:       username,
 		Bio:            bio,
 		Image:          image,
+		Roles:          roles,
+		Verified:       verified,
 	}, nil
 }
 
-func (st *Storage) GetUserWithID(id int) (*user.User, error) {
+func (st *Storage) GetUserWithID(ctx context.Context, id int) (*user.User, error) {
 
 	var email, username string
 	var createdAt, updatedAt time.Time
 	var passwordHashed []byte
 	var bioSQL, imageSQL sql.NullString
+	var roles []string
+	var verified bool
 
 	err := st.db.
-		QueryRow("SELECT email, username, password_hashed, bio, image, created_at, updated_at FROM users WHERE id=$1", id).
-		Scan(&email, &username, &passwordHashed, &bioSQL, &imageSQL, &createdAt, &updatedAt)
+		QueryRowContext(ctx, "SELECT email, username, password_hashed, bio, image, roles, verified, created_at, updated_at FROM users WHERE id=$1", id).
+		Scan(&email, &username, &passwordHashed, &bioSQL, &imageSQL, pq.Array(&roles), &verified, &createdAt, &updatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -176,29 +186,31 @@ func (st *Storage) GetUserWithID(id int) (*user.User, error) {
 		Username:       username,
 		Bio:            bio,
 		Image:          image,
+		Roles:          roles,
+		Verified:       verified,
 	}, nil
 }
 
-func (st *Storage) Delete(id int) error {
-	_, err := st.db.Exec("DELETE FROM users WHERE id = $1", id)
+func (st *Storage) Delete(ctx context.Context, id int) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (st *Storage) GetPasswordHasherWithID(id int) ([]byte, error) {
+func (st *Storage) GetPasswordHasherWithID(ctx context.Context, id int) ([]byte, error) {
 	var passwordHashed []byte
-	err := st.db.QueryRow("SELECT password_hashed FROM users WHERE id=$1", id).Scan(&passwordHashed)
+	err := st.db.QueryRowContext(ctx, "SELECT password_hashed FROM users WHERE id=$1", id).Scan(&passwordHashed)
 	if err != nil {
 		return nil, err
 	}
 	return passwordHashed, nil
 }
 
-func (st *Storage) CheckUniqueEmail(email string) (bool, error) {
+func (st *Storage) CheckUniqueEmail(ctx context.Context, email string) (bool, error) {
 	var ok bool
-	err := st.db.QueryRow("SELECT EXISTS (SELECT id FROM users WHERE email=$1)", email).Scan(&ok)
+	err := st.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT id FROM users WHERE email=$1)", email).Scan(&ok)
 	if err != nil {
 		return false, err
 	}
@@ -206,12 +218,294 @@ func (st *Storage) CheckUniqueEmail(email string) (bool, error) {
 	return ok, nil
 }
 
-func (st *Storage) CheckUniqueUsername(username string) (bool, error) {
+func (st *Storage) CheckUniqueUsername(ctx context.Context, username string) (bool, error) {
 	var ok bool
-	err := st.db.QueryRow("SELECT EXISTS (SELECT id FROM users WHERE username=$1)", username).Scan(&ok)
+	err := st.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT id FROM users WHERE username=$1)", username).Scan(&ok)
 	if err != nil {
 		return false, err
 	}
 
 	return ok, nil
 }
+
+func (st *Storage) GetRoles(ctx context.Context, id int) ([]string, error) {
+	var roles []string
+	err := st.db.QueryRowContext(ctx, "SELECT roles FROM users WHERE id=$1", id).Scan(pq.Array(&roles))
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (st *Storage) SetRoles(ctx context.Context, id int, roles []string) error {
+	result, err := st.db.ExecContext(ctx, "UPDATE users SET roles = $1, updated_at = $2 WHERE id = $3", pq.Array(roles), time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (st *Storage) AddRoleWithEmail(ctx context.Context, email, role string) error {
+	result, err := st.db.ExecContext(ctx,
+		"UPDATE users SET roles = array_append(roles, $1::varchar), updated_at = $2 WHERE email = $3 AND NOT $1 = ANY(roles)",
+		role, time.Now(), email,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var exists bool
+		err = st.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT id FROM users WHERE email=$1)", email).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+// SetVerified marks the user verified if the email is still the one the token was issued for.
+func (st *Storage) SetVerified(ctx context.Context, id int, email string) error {
+	result, err := st.db.ExecContext(ctx, "UPDATE users SET verified = true, verified_at = $1 WHERE id = $2 AND email = $3", time.Now(), id, email)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (st *Storage) IsVerified(ctx context.Context, id int) (bool, error) {
+	var verified bool
+	err := st.db.QueryRowContext(ctx, "SELECT verified FROM users WHERE id=$1", id).Scan(&verified)
+	if err != nil {
+		return false, err
+	}
+	return verified, nil
+}
+
+func (st *Storage) AddPasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO password_resets(token_hash,user_id,created_at,expires_at) VALUES($1,$2,$3,$4)",
+		tokenHash, userID, time.Now(), expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResetPassword sets the password hash of the user the token was issued to and
+// spends the token with every other unused token of the user in one transaction,
+// so a token is not spent without the password set. It returns the ID of the user
+// and sql.ErrNoRows for unknown, used or expired tokens.
+func (st *Storage) ResetPassword(ctx context.Context, tokenHash, passwordHashed []byte) (int, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	err = tx.QueryRowContext(ctx, "UPDATE password_resets SET used_at = $1 WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1 RETURNING user_id",
+		now, tokenHash,
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE password_resets SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL", now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET password_hashed = $1, updated_at = $2 WHERE id = $3", passwordHashed, now, userID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	return userID, tx.Commit()
+}
+
+func (st *Storage) GetUserIDWithIdentity(ctx context.Context, provider, subject string) (int, error) {
+	var id int
+	err := st.db.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2", provider, subject).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (st *Storage) AddIdentity(ctx context.Context, userID int, provider, subject string) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO user_identities(user_id,provider,subject,created_at) VALUES($1,$2,$3,$4)",
+		userID, provider, subject, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) GetMFA(ctx context.Context, userID int) (*user.MFA, error) {
+	mfa := &user.MFA{}
+	err := st.db.QueryRowContext(ctx, "SELECT secret, enabled FROM user_mfa WHERE user_id = $1", userID).Scan(&mfa.Secret, &mfa.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	return mfa, nil
+}
+
+func (st *Storage) SetMFASecret(ctx context.Context, userID int, secret string) error {
+	_, err := st.db.ExecContext(ctx, `INSERT INTO user_mfa(user_id,secret,enabled,last_step,created_at) VALUES($1,$2,false,0,$3)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = EXCLUDED.created_at
+	WHERE NOT user_mfa.enabled`,
+		userID, secret, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) EnableMFA(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE user_mfa SET enabled = true, enabled_at = $1 WHERE user_id = $2", time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO mfa_recovery_codes(user_id,code_hash) VALUES($1,$2)", userID, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (st *Storage) DisableMFA(ctx context.Context, userID int) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *Storage) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	result, err := st.db.ExecContext(ctx, "UPDATE user_mfa SET last_step = $1 WHERE user_id = $2 AND last_step < $1", step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (st *Storage) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error) {
+	result, err := st.db.ExecContext(ctx, "UPDATE mfa_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+		time.Now(), userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (st *Storage) AddMFAChallenge(ctx context.Context, tokenHash []byte, userID int, expiresAt time.Time) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO mfa_challenges(token_hash,user_id,attempts,expires_at) VALUES($1,$2,0,$3)",
+		tokenHash, userID, expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	// challenges live for minutes, expired ones are dropped on the way
+	_, err = st.db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE expires_at < $1", time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (st *Storage) CheckMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error) {
+	var userID int
+	err := st.db.QueryRowContext(ctx, "UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1 AND expires_at > $2 AND attempts < $3 RETURNING user_id",
+		tokenHash, time.Now(), maxAttempts,
+	).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+func (st *Storage) DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error {
+	_, err := st.db.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE token_hash = $1", tokenHash)
+	if err != nil {
+		return err
+	}
+	return nil
+}
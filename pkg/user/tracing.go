@@ -0,0 +1,200 @@
+package user
+
+import (
+	"context"
+	"rwa/pkg/tracing"
+	"time"
+)
+
+// TracedStorage is a Storage that records a span for every call.
+type TracedStorage struct {
+	Storage
+}
+
+func NewTracedStorage(storage Storage) *TracedStorage {
+	return &TracedStorage{
+		Storage: storage,
+	}
+}
+
+func (s *TracedStorage) NewUser(ctx context.Context, user *User) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.NewUser")
+	err := s.Storage.NewUser(ctx, user)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) GetUserWithEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.GetUserWithEmail")
+	result, err := s.Storage.GetUserWithEmail(ctx, email)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) GetUserWithID(ctx context.Context, id int) (*User, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.GetUserWithID")
+	result, err := s.Storage.GetUserWithID(ctx, id)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) GetPasswordHasherWithID(ctx context.Context, id int) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.GetPasswordHasherWithID")
+	result, err := s.Storage.GetPasswordHasherWithID(ctx, id)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) Update(ctx context.Context, user *User) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.Update")
+	err := s.Storage.Update(ctx, user)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) Delete(ctx context.Context, id int) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.Delete")
+	err := s.Storage.Delete(ctx, id)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) CheckUniqueUsername(ctx context.Context, username string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.CheckUniqueUsername")
+	result, err := s.Storage.CheckUniqueUsername(ctx, username)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) CheckUniqueEmail(ctx context.Context, email string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.CheckUniqueEmail")
+	result, err := s.Storage.CheckUniqueEmail(ctx, email)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) GetRoles(ctx context.Context, id int) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.GetRoles")
+	result, err := s.Storage.GetRoles(ctx, id)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) SetRoles(ctx context.Context, id int, roles []string) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.SetRoles")
+	err := s.Storage.SetRoles(ctx, id, roles)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) AddRoleWithEmail(ctx context.Context, email, role string) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.AddRoleWithEmail")
+	err := s.Storage.AddRoleWithEmail(ctx, email, role)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) SetVerified(ctx context.Context, id int, email string) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.SetVerified")
+	err := s.Storage.SetVerified(ctx, id, email)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) IsVerified(ctx context.Context, id int) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.IsVerified")
+	result, err := s.Storage.IsVerified(ctx, id)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) AddPasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.AddPasswordReset")
+	err := s.Storage.AddPasswordReset(ctx, userID, tokenHash, expiresAt)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) ResetPassword(ctx context.Context, tokenHash, passwordHashed []byte) (int, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.ResetPassword")
+	result, err := s.Storage.ResetPassword(ctx, tokenHash, passwordHashed)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) GetUserIDWithIdentity(ctx context.Context, provider, subject string) (int, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.GetUserIDWithIdentity")
+	result, err := s.Storage.GetUserIDWithIdentity(ctx, provider, subject)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) AddIdentity(ctx context.Context, userID int, provider, subject string) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.AddIdentity")
+	err := s.Storage.AddIdentity(ctx, userID, provider, subject)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) GetMFA(ctx context.Context, userID int) (*MFA, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.GetMFA")
+	result, err := s.Storage.GetMFA(ctx, userID)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) SetMFASecret(ctx context.Context, userID int, secret string) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.SetMFASecret")
+	err := s.Storage.SetMFASecret(ctx, userID, secret)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) EnableMFA(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.EnableMFA")
+	err := s.Storage.EnableMFA(ctx, userID, recoveryCodeHashes)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) DisableMFA(ctx context.Context, userID int) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.DisableMFA")
+	err := s.Storage.DisableMFA(ctx, userID)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.UseMFAStep")
+	result, err := s.Storage.UseMFAStep(ctx, userID, step)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.UseRecoveryCode")
+	result, err := s.Storage.UseRecoveryCode(ctx, userID, codeHash)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) AddMFAChallenge(ctx context.Context, tokenHash []byte, userID int, expiresAt time.Time) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.AddMFAChallenge")
+	err := s.Storage.AddMFAChallenge(ctx, tokenHash, userID, expiresAt)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *TracedStorage) CheckMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error) {
+	ctx, span := tracing.Start(ctx, "user.Storage.CheckMFAChallenge")
+	result, err := s.Storage.CheckMFAChallenge(ctx, tokenHash, maxAttempts)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (s *TracedStorage) DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error {
+	ctx, span := tracing.Start(ctx, "user.Storage.DeleteMFAChallenge")
+	err := s.Storage.DeleteMFAChallenge(ctx, tokenHash)
+	tracing.End(span, err)
+	return err
+}
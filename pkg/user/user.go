@@ -1,45 +1,105 @@
 package user
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"net/http"
+	"rwa/pkg/lockout"
+	"rwa/pkg/logging"
+	"rwa/pkg/mail"
+	"rwa/pkg/metrics"
+	"rwa/pkg/oauth"
+	"rwa/pkg/rbac"
 	"rwa/pkg/utils"
+	"strconv"
 	"time"
+
+	"github.com/gorilla/mux"
 )
 
+var logger = logging.For("user")
+
 type UserHandler struct {
 	Storage        Storage
 	SessionManager SessionManager
+	// LoginGuard limits failed login attempts, nil disables the protection.
+	LoginGuard *lockout.Guard
+	// Mailer and Verification send email verification links, nil disables them.
+	Mailer       mail.Mailer
+	Verification *VerificationTokens
+	// PublicURL is the address of the API used in links sent by mail.
+	PublicURL string
+	// PasswordResetTTL is how long a password reset token is valid.
+	PasswordResetTTL time.Duration
+	// OAuth signs users in with OpenID Connect providers, nil disables it.
+	OAuth *oauth.Providers
+	// MFAIssuer names the service in authenticator apps, MFAChallengeTTL is
+	// how long the mfa token from the first login step is valid.
+	MFAIssuer       string
+	MFAChallengeTTL time.Duration
+	// Passwords hashes and verifies passwords, hashes not made by its preferred
+	// hasher with its current parameters are rehashed on the next successful login.
+	Passwords  *Passwords
+	adminEmail string
+	// passwordResets queues the emails of ForgotPassword for SendPasswordResets.
+	passwordResets chan queuedReset
 }
 
 func NewUserHandler(st Storage, sm SessionManager) *UserHandler {
 	return &UserHandler{
 		Storage:        st,
 		SessionManager: sm,
+		Passwords:      DefaultPasswords(),
+		passwordResets: make(chan queuedReset, passwordResetQueue),
 	}
 }
 
 type Storage interface {
-	NewUser(user *User) error
-	GetUserWithEmail(email string) (*User, error)
-	GetUserWithID(id int) (*User, error)
-	GetPasswordHasherWithID(id int) ([]byte, error)
-	Update(*User) error
-	Delete(id int) error
-	CheckUniqueUsername(username string) (bool, error)
-	CheckUniqueEmail(email string) (bool, error)
+	NewUser(ctx context.Context, user *User) error
+	GetUserWithEmail(ctx context.Context, email string) (*User, error)
+	GetUserWithID(ctx context.Context, id int) (*User, error)
+	GetPasswordHasherWithID(ctx context.Context, id int) ([]byte, error)
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, id int) error
+	CheckUniqueUsername(ctx context.Context, username string) (bool, error)
+	CheckUniqueEmail(ctx context.Context, email string) (bool, error)
+	GetRoles(ctx context.Context, id int) ([]string, error)
+	SetRoles(ctx context.Context, id int, roles []string) error
+	AddRoleWithEmail(ctx context.Context, email, role string) error
+	SetVerified(ctx context.Context, id int, email string) error
+	IsVerified(ctx context.Context, id int) (bool, error)
+	AddPasswordReset(ctx context.Context, userID int, tokenHash []byte, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash, passwordHashed []byte) (int, error)
+	GetUserIDWithIdentity(ctx context.Context, provider, subject string) (int, error)
+	AddIdentity(ctx context.Context, userID int, provider, subject string) error
+	GetMFA(ctx context.Context, userID int) (*MFA, error)
+	// SetMFASecret starts a new enrollment, it does not change an enabled one.
+	SetMFASecret(ctx context.Context, userID int, secret string) error
+	// EnableMFA enables the enrollment and replaces the recovery codes.
+	EnableMFA(ctx context.Context, userID int, recoveryCodeHashes [][]byte) error
+	DisableMFA(ctx context.Context, userID int) error
+	// UseMFAStep records the step of an accepted code, it returns false if
+	// the step or a later one was already used.
+	UseMFAStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash []byte) (bool, error)
+	AddMFAChallenge(ctx context.Context, tokenHash []byte, userID int, expiresAt time.Time) error
+	// CheckMFAChallenge counts an attempt, it returns sql.ErrNoRows for unknown,
+	// expired and exhausted challenges.
+	CheckMFAChallenge(ctx context.Context, tokenHash []byte, maxAttempts int) (int, error)
+	DeleteMFAChallenge(ctx context.Context, tokenHash []byte) error
 	GetErrNoUpdate() error
 }
 
 type SessionManager interface {
-	Create(userID int) (string, error)
+	// Create starts a session and writes its credentials to the response headers.
+	Create(w http.ResponseWriter, r *http.Request, userID int) error
 	Delete(r *http.Request) error
 	DeleteAll(r *http.Request) error
+	DeleteAllWithID(ctx context.Context, userID int) error
 	AuthMiddleware(next http.Handler) http.Handler
 	IdFromSessionContext(r *http.Request) (int, error)
+	HasPermission(r *http.Request, permission string) bool
 }
 
 type User struct {
@@ -52,6 +112,8 @@ type User struct {
 	Username       string    `json:"username"`
 	Bio            *string   `json:"bio"`
 	Image          *string   `json:"image"`
+	Roles          []string  `json:"roles"`
+	Verified       bool      `json:"verified"`
 }
 
 func (uh *UserHandler) checkUniqueEmail(w http.ResponseWriter, r *http.Request, email string) bool {
@@ -61,9 +123,9 @@ func (uh *UserHandler) checkUniqueEmail(w http.ResponseWriter, r *http.Request,
 		return false
 	}
 
-	unique, err := uh.Storage.CheckUniqueEmail(email)
+	unique, err := uh.Storage.CheckUniqueEmail(r.Context(), email)
 	if err != nil {
-		log.Printf("checking unique email error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "checking unique email error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return false
 	}
@@ -83,9 +145,9 @@ func (uh *UserHandler) checkUniqueUsername(w http.ResponseWriter, r *http.Reques
 		return false
 	}
 
-	unique, err := uh.Storage.CheckUniqueUsername(username)
+	unique, err := uh.Storage.CheckUniqueUsername(r.Context(), username)
 	if err != nil {
-		log.Printf("checking unique username error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "checking unique username error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return false
 	}
@@ -121,20 +183,37 @@ func (uh *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		utils.SendErrMessage(w, r, "password must be not empty", http.StatusBadRequest)
 		return
 	}
+	if !checkPasswordLength(w, r, newUser.Password) {
+		return
+	}
 
 	now := time.Now()
 	newUser.CreatedAt = now
 	newUser.UpdatedAt = now
 
-	salt := randStringRunes(8)
-	newUser.PasswordHashed = hashPassword(newUser.Password, salt)
+	passwordHashed, err := uh.Passwords.Hash(newUser.Password)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "hash password error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	newUser.PasswordHashed = passwordHashed
+
+	// the admin role of ADMIN_EMAIL is granted only when the email is verified
+	newUser.Roles = append([]string{}, rbac.DefaultRoles...)
 
-	err := uh.Storage.NewUser(newUser)
+	err = uh.Storage.NewUser(r.Context(), newUser)
 	if err != nil {
-		log.Printf("add new user to storage error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "add new user to storage error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	metrics.Registrations.Inc()
+
+	err = uh.sendVerification(r.Context(), newUser.ID, newUser.Email)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "send verification mail error", "error", err)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -156,40 +235,90 @@ func (uh *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := uh.Storage.GetUserWithEmail(userFromReq.Email)
+	ip := utils.ClientIP(r)
+	if uh.LoginGuard != nil {
+		wait, err := uh.LoginGuard.Check(r.Context(), userFromReq.Email, ip)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "check login attempts error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			utils.SendErrMessage(w, r, "too many login attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	user, err := uh.Storage.GetUserWithEmail(r.Context(), userFromReq.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			utils.SendErrMessage(w, r, "invalid email or password", http.StatusBadRequest)
+			// hash anyway, so the response time does not tell that the email is unknown
+			uh.Passwords.Hash(userFromReq.Password)
+			uh.loginFailed(w, r, userFromReq.Email, ip, nil, "invalid email or password")
 			return
 		}
-		log.Printf("get user info with email error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user info with email error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	salt := string(user.PasswordHashed[0:8])
-	userFromReq.PasswordHashed = hashPassword(userFromReq.Password, salt)
-
-	if err == sql.ErrNoRows || !bytes.Equal(user.PasswordHashed, userFromReq.PasswordHashed) {
-		utils.SendErrMessage(w, r, "invalid email or password", http.StatusBadRequest)
+	match, rehash, err := uh.Passwords.Verify(userFromReq.Password, user.PasswordHashed)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "verify password error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	sessionKey, err := uh.SessionManager.Create(user.ID)
-	if err != nil {
-		log.Printf("create session key error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
-		w.WriteHeader(http.StatusInternalServerError)
+	if !match {
+		uh.loginFailed(w, r, userFromReq.Email, ip, user, "invalid email or password")
 		return
 	}
 
-	w.Header().Set("Authorization", sessionKey)
+	if rehash {
+		uh.rehashPassword(r, user.ID, userFromReq.Password)
+	}
 
-	response := utils.Response{
-		"user": user,
+	if uh.LoginGuard != nil {
+		err = uh.LoginGuard.Success(r.Context(), userFromReq.Email, ip)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "reset login attempts error", "error", err)
+		}
 	}
 
-	utils.SendResponse(w, r, response)
+	uh.startSession(w, r, user)
+}
+
+// rehashPassword replaces the hash of a verified password, a failure
+// only leaves the old hash in place until the next login.
+func (uh *UserHandler) rehashPassword(r *http.Request, userID int, password string) {
+	passwordHashed, err := uh.Passwords.Hash(password)
+	if err == nil {
+		err = uh.Storage.Update(r.Context(), &User{
+			ID:             userID,
+			PasswordHashed: passwordHashed,
+		})
+	}
+	if err != nil {
+		logger.ErrorContext(r.Context(), "rehash password error", "error", err)
+	}
+}
 
+// loginFailed registers the failed attempt and answers with the message. The message is
+// the same for a wrong password and for an unknown email (user is nil then).
+func (uh *UserHandler) loginFailed(w http.ResponseWriter, r *http.Request, email, ip string, user *User, message string) {
+	if uh.LoginGuard != nil {
+		lockedUntil, err := uh.LoginGuard.Fail(r.Context(), email, ip)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "register failed login error", "error", err)
+		}
+		if user != nil && !lockedUntil.IsZero() {
+			uh.LoginGuard.NotifyLockout(r.Context(), user.Email, lockedUntil)
+		}
+	}
+	metrics.Logins.WithLabelValues("failure").Inc()
+
+	utils.SendErrMessage(w, r, message, http.StatusBadRequest)
 }
 
 func (uh *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -200,14 +329,14 @@ func (uh *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	case "":
 		err := uh.SessionManager.Delete(r)
 		if err != nil {
-			log.Printf("delete session error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+			logger.ErrorContext(r.Context(), "delete session error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 	case "true":
 		err := uh.SessionManager.DeleteAll(r)
 		if err != nil {
-			log.Printf("delete all sessions error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+			logger.ErrorContext(r.Context(), "delete all sessions error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -219,14 +348,14 @@ func (uh *UserHandler) GetUserInfo(w http.ResponseWriter, r *http.Request) {
 
 	id, err := uh.SessionManager.IdFromSessionContext(r)
 	if err != nil {
-		log.Printf("get user id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	user, err := uh.Storage.GetUserWithID(id)
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
 	if err != nil {
-		log.Printf("get user info with id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user info with id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -247,9 +376,16 @@ func (uh *UserHandler) UpdateUserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the email and the password are credentials: a personal access token with
+	// user:write must not be enough to take the account over
+	if (userFromReq.Email != "" || userFromReq.Password != "") && !uh.SessionManager.HasPermission(r, rbac.PermCredentialsManage) {
+		utils.SendErrMessage(w, r, "changing the email or the password needs the permission "+rbac.PermCredentialsManage, http.StatusForbidden)
+		return
+	}
+
 	id, err := uh.SessionManager.IdFromSessionContext(r)
 	if err != nil {
-		log.Printf("getting user id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "getting user id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -262,39 +398,58 @@ func (uh *UserHandler) UpdateUserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if userFromReq.Password != "" && !checkPasswordLength(w, r, userFromReq.Password) {
+		return
+	}
+
 	if userFromReq.Password != "" {
-		NewPasswordHashed, err := uh.Storage.GetPasswordHasherWithID(id)
+		NewPasswordHashed, err := uh.Storage.GetPasswordHasherWithID(r.Context(), id)
 		if err != nil {
-			log.Printf("getting hashed password error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+			logger.ErrorContext(r.Context(), "getting hashed password error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		samePassword, _, err := uh.Passwords.Verify(userFromReq.Password, NewPasswordHashed)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "verify password error", "error", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		salt := string(NewPasswordHashed[0:8])
-		OLDpasswordHashed := hashPassword(userFromReq.Password, salt)
 
-		if bytes.Equal(NewPasswordHashed, OLDpasswordHashed) {
+		if samePassword {
 			utils.SendErrMessage(w, r, "you write your old password, need make new password", http.StatusBadRequest)
 			return
 		}
 
-		salt = randStringRunes(8)
-		userFromReq.PasswordHashed = hashPassword(userFromReq.Password, salt)
+		userFromReq.PasswordHashed, err = uh.Passwords.Hash(userFromReq.Password)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "hash password error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
 	userFromReq.ID = id
-	err = uh.Storage.Update(userFromReq)
+	err = uh.Storage.Update(r.Context(), userFromReq)
 	if err != nil {
 		if err == uh.Storage.GetErrNoUpdate() {
 			utils.SendErrMessage(w, r, "no user data to update", http.StatusBadRequest)
 			return
 		}
-		log.Printf("update user data error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "update user data error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	user, err := uh.Storage.GetUserWithID(id)
+	if userFromReq.Email != "" {
+		err = uh.sendVerification(r.Context(), id, userFromReq.Email)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "send verification mail error", "error", err)
+		}
+	}
+
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
 	if err != nil {
-		fmt.Println("error with get user with id from db", r.URL.Path, err)
+		logger.ErrorContext(r.Context(), "get user with id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -308,15 +463,205 @@ func (uh *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	id, err := uh.SessionManager.IdFromSessionContext(r)
 	if err != nil {
-		log.Printf("get user id error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = uh.Storage.Delete(id)
+	err = uh.Storage.Delete(r.Context(), id)
 	if err != nil {
-		log.Printf("delete user error: [%s], path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "delete user error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	err = uh.SessionManager.DeleteAllWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "delete all sessions error", "error", err)
+	}
+}
+
+// BootstrapAdmin grants the admin role to the user with the given email if the
+// email is verified. Otherwise the role is granted when the user verifies it, so
+// whoever registers first with the email does not get the role.
+func (uh *UserHandler) BootstrapAdmin(ctx context.Context, email string) error {
+	if email == "" {
+		return nil
+	}
+	uh.adminEmail = email
+
+	user, err := uh.Storage.GetUserWithEmail(ctx, email)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.Verified {
+		return nil
+	}
+	return uh.Storage.AddRoleWithEmail(ctx, email, rbac.RoleAdmin)
+}
+
+func (uh *UserHandler) SetRoles(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.SendErrMessage(w, r, "bad user id", http.StatusBadRequest)
+		return
+	}
+
+	body := utils.ReadBody(w, r)
+	if body == nil {
+		return
+	}
+
+	userFromReq := unmarshalBody(w, r, body)
+	if userFromReq == nil {
+		return
+	}
+
+	if len(userFromReq.Roles) == 0 {
+		utils.SendErrMessage(w, r, "roles must be not empty", http.StatusBadRequest)
+		return
+	}
+
+	for _, role := range userFromReq.Roles {
+		if !rbac.IsValidRole(role) {
+			utils.SendErrMessage(w, r, fmt.Sprintf("unknown role: %s", role), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = uh.Storage.SetRoles(r.Context(), id, userFromReq.Roles)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, "bad id, no user", http.StatusBadRequest)
+			return
+		}
+		logger.ErrorContext(r.Context(), "set user roles error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user info with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := utils.Response{"user": user}
+
+	utils.SendResponse(w, r, response)
+}
+
+func (uh *UserHandler) sendVerification(ctx context.Context, userID int, email string) error {
+	if uh.Mailer == nil || uh.Verification == nil {
+		return nil
+	}
+
+	link := fmt.Sprintf("%s/api/users/verify?token=%s", uh.PublicURL, uh.Verification.Issue(userID, email))
+
+	return uh.Mailer.Send(ctx, &mail.Message{
+		To:      email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf("To confirm your email open the link:\n%s\n\nThe link is valid for %s.\n",
+			link, uh.Verification.TTL),
+	})
+}
+
+func (uh *UserHandler) Verify(w http.ResponseWriter, r *http.Request) {
+
+	if uh.Verification == nil {
+		utils.SendErrMessage(w, r, "email verification is disabled", http.StatusNotFound)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		utils.SendErrMessage(w, r, "token must be not empty", http.StatusBadRequest)
+		return
+	}
+
+	id, email, err := uh.Verification.Parse(token)
+	if err != nil {
+		utils.SendErrMessage(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = uh.Storage.SetVerified(r.Context(), id, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.SendErrMessage(w, r, errBadToken.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.ErrorContext(r.Context(), "set user verified error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if uh.adminEmail != "" && email == uh.adminEmail {
+		err = uh.Storage.AddRoleWithEmail(r.Context(), email, rbac.RoleAdmin)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "grant admin role error", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := utils.Response{
+		"user": utils.Response{
+			"email":    email,
+			"verified": true,
+		},
+	}
+
+	utils.SendResponse(w, r, response)
+}
+
+func (uh *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+
+	id, err := uh.SessionManager.IdFromSessionContext(r)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := uh.Storage.GetUserWithID(r.Context(), id)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "get user info with id error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if user.Verified {
+		utils.SendErrMessage(w, r, "email is already verified", http.StatusBadRequest)
+		return
+	}
+
+	err = uh.sendVerification(r.Context(), user.ID, user.Email)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "send verification mail error", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// NotifyLockout mails the account owner about the lockout, it makes UserHandler a lockout.Notifier.
+func (uh *UserHandler) NotifyLockout(ctx context.Context, email string, until time.Time) error {
+	if uh.Mailer == nil {
+		return nil
+	}
+
+	return uh.Mailer.Send(ctx, &mail.Message{
+		To:      email,
+		Subject: "Your account is temporarily locked",
+		Body: fmt.Sprintf("There were too many failed attempts to sign in to your account.\n"+
+			"Signing in is locked until %s.\n\nIf it was not you, consider changing your password.\n",
+			until.Format(time.RFC1123)),
+	})
 }
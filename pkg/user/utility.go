@@ -2,7 +2,6 @@ package user
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"rwa/pkg/utils"
 )
@@ -11,7 +10,7 @@ func unmarshalBody(w http.ResponseWriter, r *http.Request, body []byte) *User {
 	dataFromBody := make(map[string]*User)
 	err := json.Unmarshal(body, &dataFromBody)
 	if err != nil {
-		log.Printf("unmarshal body json error: [%s]; path: [%s], method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.WarnContext(r.Context(), "unmarshal body json error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return nil
 	}
@@ -3,11 +3,14 @@ package utils
 import (
 	"encoding/json"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"rwa/pkg/logging"
 	"time"
 )
 
+var logger = logging.For("utils")
+
 type Response map[string]interface{}
 
 func SendErrMessage(w http.ResponseWriter, r *http.Request, text string, code int) {
@@ -22,7 +25,7 @@ func SendErrMessage(w http.ResponseWriter, r *http.Request, text string, code in
 
 	dataResponse, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("marshal response with error; error: [%s]; path: [%s]\n; method: [%s]; error message: [%s]\n", err.Error(), r.URL.Path, r.Method, text)
+		logger.ErrorContext(r.Context(), "marshal error response error", "error", err, "message", text)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -33,7 +36,7 @@ func SendErrMessage(w http.ResponseWriter, r *http.Request, text string, code in
 func SendResponse(w http.ResponseWriter, r *http.Request, response Response) {
 	dataResponse, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("marshal response error: [%s]; path: [%s]\n; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "marshal response error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -43,9 +46,17 @@ func SendResponse(w http.ResponseWriter, r *http.Request, response Response) {
 func ReadBody(w http.ResponseWriter, r *http.Request) []byte {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("read body error: [%s]; path: [%s]; method: [%s]\n", err.Error(), r.URL.Path, r.Method)
+		logger.ErrorContext(r.Context(), "read body error", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return nil
 	}
 	return body
 }
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}